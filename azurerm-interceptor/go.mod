@@ -0,0 +1,3 @@
+module github.com/ms-henglu/azurerm-interceptor
+
+go 1.24.1
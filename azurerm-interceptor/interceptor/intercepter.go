@@ -0,0 +1,320 @@
+package interceptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	InterceptedErrorCode = "InterceptedError"
+
+	// DefaultScope is the cache scope of the requests that don't belong to any scope
+	DefaultScope = ""
+)
+
+var (
+	// cache stores the intercepted request bodies, keyed by the cache scope and then the request URL
+	cache      = make(map[string]map[string]string)
+	cacheMutex sync.RWMutex
+
+	// ignoredHeaders are the x-ms-* headers which are different for every request
+	ignoredHeaders = map[string]bool{
+		"x-ms-client-request-id":        true,
+		"x-ms-correlation-request-id":   true,
+		"x-ms-return-client-request-id": true,
+	}
+
+	partnerIdRegex = regexp.MustCompile(`pid-([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})`)
+)
+
+var (
+	// session stores the synthetic resources of the session, keyed by the lowercased resource ID.
+	// It's shared by all scopes, the GET requests which aren't answered by the cache of their scope are answered from it.
+	session      = make(map[string]string)
+	sessionMutex sync.RWMutex
+)
+
+type scopeContextKey struct{}
+
+type recorderContextKey struct{}
+
+var (
+	// recorders are the active recorders, keyed by the cache scope
+	recorders      = make(map[string]*Recorder)
+	recordersMutex sync.RWMutex
+)
+
+// CapturedRequest is a request which is intercepted instead of being sent.
+type CapturedRequest struct {
+	Method  string
+	URL     string
+	Body    string
+	Headers map[string]string
+}
+
+// Recorder records the requests intercepted in a scope, in the order they're sent.
+type Recorder struct {
+	scope    string
+	mutex    sync.Mutex
+	requests []CapturedRequest
+}
+
+// NewRecorder starts recording the requests intercepted in the scope, it replaces the active recorder of the scope.
+// The requests which carry a recorder in their context are recorded by that recorder instead. Close stops the recording.
+func NewRecorder(scope string) *Recorder {
+	recorder := &Recorder{
+		scope: strings.ToLower(scope),
+	}
+	recordersMutex.Lock()
+	defer recordersMutex.Unlock()
+	recorders[recorder.scope] = recorder
+	return recorder
+}
+
+// WithRecorder returns a copy of the context whose requests are recorded by the recorder.
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, recorderContextKey{}, recorder)
+}
+
+// Requests returns the recorded requests.
+func (r *Recorder) Requests() []CapturedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	out := make([]CapturedRequest, len(r.requests))
+	copy(out, r.requests)
+	return out
+}
+
+// Close stops recording the requests of the scope.
+func (r *Recorder) Close() {
+	recordersMutex.Lock()
+	defer recordersMutex.Unlock()
+	if recorders[r.scope] == r {
+		delete(recorders, r.scope)
+	}
+}
+
+func (r *Recorder) record(request CapturedRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, request)
+}
+
+func requestRecorder(req *http.Request) *Recorder {
+	if recorder, ok := req.Context().Value(recorderContextKey{}).(*Recorder); ok && recorder != nil {
+		return recorder
+	}
+	recordersMutex.RLock()
+	defer recordersMutex.RUnlock()
+	return recorders[RequestScope(req)]
+}
+
+// WithScope returns a copy of the context whose requests are cached in the scope.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+// RequestScope returns the cache scope of the request. The scope is taken from the request context,
+// or from the partner ID in the user agent, because most azurerm resources don't send their requests with the apply context.
+func RequestScope(req *http.Request) string {
+	if scope, ok := req.Context().Value(scopeContextKey{}).(string); ok && scope != "" {
+		return scope
+	}
+	if matches := partnerIdRegex.FindStringSubmatch(req.UserAgent()); len(matches) == 2 {
+		return strings.ToLower(matches[1])
+	}
+	return DefaultScope
+}
+
+// ResetScope removes the cached request bodies of the scope, so the next apply in the scope can't see the bodies of the previous one.
+func ResetScope(scope string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	delete(cache, strings.ToLower(scope))
+}
+
+// SetSessionResource stores the JSON body of a resource in the session, it's returned for the GET requests of the resource ID.
+func SetSessionResource(resourceId string, body string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	session[sessionKey(resourceId)] = body
+}
+
+// ResetSession removes all resources of the session.
+func ResetSession() {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	session = make(map[string]string)
+}
+
+func getSessionResource(resourceId string) string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return session[sessionKey(resourceId)]
+}
+
+func sessionKey(resourceId string) string {
+	return strings.ToLower(strings.TrimSuffix(resourceId, "/"))
+}
+
+func getCache(scope string, url string) string {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return cache[scope][url]
+}
+
+func setCache(scope string, url string, body string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if cache[scope] == nil {
+		cache[scope] = make(map[string]string)
+	}
+	cache[scope][url] = body
+}
+
+func HandleRequest(req *http.Request) (*http.Response, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request is nil")
+	}
+
+	if req.Method == "POST" && strings.Contains(req.URL.Path, "checkNameAvailability") {
+		response := make(map[string]bool)
+		response["nameAvailable"] = true
+		data, _ := json.Marshal(response)
+
+		return &http.Response{
+			StatusCode: 200,
+			Header: map[string][]string{
+				"Content-Type":   {"application/json"},
+				"Content-Length": {fmt.Sprintf("%d", len(data))},
+			},
+			ContentLength: int64(len(data)),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			Request:       req,
+		}, nil
+	}
+
+	if req.Method == "GET" || req.Method == "HEAD" {
+		existing := getCache(RequestScope(req), req.URL.String())
+		if existing == "" {
+			existing = getSessionResource(req.URL.Path)
+		}
+		if existing != "" {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(bytes.NewReader([]byte(existing))),
+				Header: map[string][]string{
+					"Content-Type":   {"application/json"},
+					"Content-Length": {fmt.Sprintf("%d", len([]byte(existing)))},
+				},
+				ContentLength: int64(len([]byte(existing))),
+				Request:       req,
+			}, nil
+		}
+
+		return &http.Response{
+			StatusCode: 404,
+			Body:       http.NoBody,
+			Header: map[string][]string{
+				"Content-Type": {"application/json"},
+			},
+			Request: req,
+		}, nil
+	}
+
+	if req.Method == "PUT" || req.Method == "PATCH" || req.Method == "POST" {
+		requestBody := requestBodyString(req)
+		headers := requestHeaders(req)
+		model := ServiceError{
+			Code:    InterceptedErrorCode,
+			Message: InterceptedErrorCode,
+			InnerError: map[string]interface{}{
+				"method":  req.Method,
+				"url":     req.URL.String(),
+				"body":    requestBody,
+				"headers": headers,
+			},
+		}
+		data, _ := json.Marshal(model)
+
+		if recorder := requestRecorder(req); recorder != nil {
+			recorder.record(CapturedRequest{
+				Method:  req.Method,
+				URL:     req.URL.String(),
+				Body:    requestBody,
+				Headers: headers,
+			})
+		}
+
+		setCache(RequestScope(req), req.URL.String(), requestBody)
+
+		return &http.Response{
+			StatusCode: 400,
+			Header: map[string][]string{
+				"Content-Type":   {"application/json"},
+				"Content-Length": {fmt.Sprintf("%d", len(data))},
+			},
+			ContentLength: int64(len(data)),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			Request:       req,
+		}, nil
+	}
+
+	return &http.Response{
+		StatusCode: 400,
+		Header: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+		Body:    http.NoBody,
+		Request: req,
+	}, nil
+}
+
+// requestHeaders returns the conditional and the ARM specific headers of the request, like If-Match and x-ms-*.
+// The headers which are different for every request, like the client request ID, are omitted.
+func requestHeaders(req *http.Request) map[string]string {
+	out := make(map[string]string)
+	for key, values := range req.Header {
+		if len(values) == 0 {
+			continue
+		}
+		name := strings.ToLower(key)
+		switch {
+		case name == "if-match" || name == "if-none-match":
+		case strings.HasPrefix(name, "x-ms-") && !ignoredHeaders[name]:
+		default:
+			continue
+		}
+		out[http.CanonicalHeaderKey(key)] = strings.Join(values, ",")
+	}
+	return out
+}
+
+func requestBodyString(req *http.Request) string {
+	if req == nil || req.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		body = []byte(err.Error())
+	} else {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return string(body)
+}
+
+type ServiceError struct {
+	Code           string                   `json:"code"`
+	Message        string                   `json:"message"`
+	Target         *string                  `json:"target"`
+	Details        []map[string]interface{} `json:"details"`
+	InnerError     map[string]interface{}   `json:"innererror"`
+	AdditionalInfo []map[string]interface{} `json:"additionalInfo"`
+}
@@ -0,0 +1,75 @@
+package interceptor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const vnetUrl = "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01"
+
+func handle(t *testing.T, ctx context.Context, method string, url string, body string) (int, string) {
+	req := httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx)
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("X-Ms-Client-Request-Id", "00000000-0000-0000-0000-000000000000")
+	resp, err := HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func Test_HandleRequest_Scope(t *testing.T) {
+	scope := "scope-a"
+	ctx := WithScope(context.TODO(), scope)
+	defer ResetScope(scope)
+
+	if status, _ := handle(t, ctx, http.MethodGet, vnetUrl, ""); status != http.StatusNotFound {
+		t.Fatalf("Expected 404 before the PUT, got %d", status)
+	}
+
+	recorder := NewRecorder(scope)
+	status, _ := handle(t, WithRecorder(ctx, recorder), http.MethodPut, vnetUrl, `{"location":"westeurope"}`)
+	recorder.Close()
+	if status != http.StatusBadRequest {
+		t.Fatalf("Expected the PUT to be intercepted with 400, got %d", status)
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 || requests[0].Method != http.MethodPut || requests[0].Body != `{"location":"westeurope"}` {
+		t.Fatalf("Expected the recorded PUT, got %v", requests)
+	}
+	if headers := requests[0].Headers; len(headers) != 1 || headers["If-Match"] != "*" {
+		t.Fatalf("Expected only the If-Match header, got %v", headers)
+	}
+
+	if status, body := handle(t, ctx, http.MethodGet, vnetUrl, ""); status != http.StatusOK || body != `{"location":"westeurope"}` {
+		t.Fatalf("Expected the cached body, got %d %s", status, body)
+	}
+	if status, _ := handle(t, WithScope(context.TODO(), "scope-b"), http.MethodGet, vnetUrl, ""); status != http.StatusNotFound {
+		t.Fatalf("Expected 404 in another scope, got %d", status)
+	}
+	ResetScope(scope)
+	if status, _ := handle(t, ctx, http.MethodGet, vnetUrl, ""); status != http.StatusNotFound {
+		t.Fatalf("Expected 404 after the scope is reset, got %d", status)
+	}
+}
+
+func Test_HandleRequest_Session(t *testing.T) {
+	defer ResetSession()
+	SetSessionResource("/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/VNET/", `{"name":"vnet"}`)
+
+	if status, body := handle(t, context.TODO(), http.MethodGet, vnetUrl, ""); status != http.StatusOK || body != `{"name":"vnet"}` {
+		t.Fatalf("Expected the session resource, got %d %s", status, body)
+	}
+	ResetSession()
+	if status, _ := handle(t, context.TODO(), http.MethodGet, vnetUrl, ""); status != http.StatusNotFound {
+		t.Fatalf("Expected 404 after the session is reset, got %d", status)
+	}
+}
@@ -6,12 +6,17 @@ require (
 	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.17.0
 	github.com/Azure/azure-sdk-for-go/sdk/azidentity v1.8.2
 	github.com/Azure/go-autorest/autorest v0.11.30
+	github.com/google/uuid v1.6.0
 	github.com/hashicorp/hc-install v0.9.2
+	github.com/hashicorp/hcl/v2 v2.23.0
 	github.com/hashicorp/terraform-exec v0.23.0
 	github.com/hashicorp/terraform-json v0.25.0
 	github.com/hashicorp/terraform-plugin-go v0.27.0
 	github.com/hashicorp/terraform-provider-azurerm v1.44.1-0.20241213080124-36996bc68a4a
+	github.com/ms-henglu/azurerm-interceptor v0.0.0-20250424065430-32d17ffe88f1
 	github.com/sirupsen/logrus v1.9.3
+	github.com/zclconf/go-cty v1.16.2
+	google.golang.org/protobuf v1.36.6
 )
 
 require (
@@ -36,7 +41,6 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.2 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/google/go-cmp v0.7.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-azure-helpers v0.74.0 // indirect
 	github.com/hashicorp/go-azure-sdk/resource-manager v0.20250814.1105543 // indirect
@@ -50,7 +54,6 @@ require (
 	github.com/hashicorp/go-retryablehttp v0.7.7 // indirect
 	github.com/hashicorp/go-uuid v1.0.3 // indirect
 	github.com/hashicorp/go-version v1.7.0 // indirect
-	github.com/hashicorp/hcl/v2 v2.23.0 // indirect
 	github.com/hashicorp/logutils v1.0.0 // indirect
 	github.com/hashicorp/terraform-plugin-framework v1.15.0 // indirect
 	github.com/hashicorp/terraform-plugin-framework-validators v0.18.0 // indirect
@@ -72,7 +75,6 @@ require (
 	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
-	github.com/ms-henglu/azurerm-interceptor v0.0.0-20250424065430-32d17ffe88f1 // indirect
 	github.com/oklog/run v1.1.0 // indirect
 	github.com/pkg/browser v0.0.0-20240102092130-5ac0b6a4141c // indirect
 	github.com/rickb777/date v1.12.5-0.20200422084442-6300e543c4d9 // indirect
@@ -80,7 +82,6 @@ require (
 	github.com/vmihailenco/msgpack v4.0.4+incompatible // indirect
 	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
-	github.com/zclconf/go-cty v1.16.2 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/mod v0.26.0 // indirect
 	golang.org/x/net v0.42.0 // indirect
@@ -92,7 +93,6 @@ require (
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
 	google.golang.org/grpc v1.72.1 // indirect
-	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	software.sslmate.com/src/go-pkcs12 v0.4.0 // indirect
 )
@@ -101,4 +101,6 @@ replace (
 	github.com/Azure/go-autorest/autorest => ./submodules/go-autorest/autorest
 	github.com/hashicorp/go-azure-sdk/sdk => ./submodules/go-azure-sdk/sdk
 	github.com/hashicorp/terraform-provider-azurerm => ./submodules/terraform-provider-azurerm
+	// the local interceptor module is replaced by a release of github.com/ms-henglu/azurerm-interceptor once its changes are upstreamed
+	github.com/ms-henglu/azurerm-interceptor => ./azurerm-interceptor
 )
@@ -9,6 +9,7 @@ import (
 
 	"github.com/Azure/aztfpreflight/internal/utils"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
@@ -24,6 +25,9 @@ type Client struct {
 
 var c *Client
 
+// pollingFrequency is the interval of polling a long-running operation, if its responses don't have a Retry-After header.
+var pollingFrequency = 5 * time.Second
+
 // envTokenCredential is a simple TokenCredential implementation that returns
 // a static token read from the environment. This allows callers to supply a
 // bearer token via an environment variable (e.g. for CI or debugging).
@@ -66,7 +70,15 @@ func NewClient() (*Client, error) {
 		}
 	}
 
-	pl, err := armruntime.NewPipeline("aztfpreflight", "dev", cred, runtime.PipelineOptions{}, nil)
+	// the throttled requests are not retried by the pipeline, PreflightInBatch retries them and records the retries
+	clientOptions := &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Retry: policy.RetryOptions{
+				StatusCodes: []int{http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+			},
+		},
+	}
+	pl, err := armruntime.NewPipeline("aztfpreflight", "dev", cred, runtime.PipelineOptions{}, clientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +101,8 @@ func DefaultSharedClient() (*Client, error) {
 	return c, nil
 }
 
+// Execute sends the request and returns the response body. The long-running operations are polled until they finish or the context is done,
+// and the body of their final result is returned.
 func Execute[ResponseT interface{}](ctx context.Context, client *Client, method string, url string, apiVersion string, body interface{}) (*ResponseT, error) {
 	logrus.Debugf("Executing request %s %s", method, url)
 	req, err := runtime.NewRequest(ctx, method, runtime.JoinPaths(client.host, url))
@@ -111,6 +125,19 @@ func Execute[ResponseT interface{}](ctx context.Context, client *Client, method
 	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent) {
 		return nil, runtime.NewResponseError(resp)
 	}
+	if resp.StatusCode == http.StatusAccepted {
+		// the request is a long-running operation, its result is read by following the Azure-AsyncOperation or Location headers
+		logrus.Debugf("Polling the long-running operation of %s %s", method, url)
+		poller, err := runtime.NewPoller[ResponseT](resp, client.pl, nil)
+		if err != nil {
+			return nil, err
+		}
+		responseBody, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: pollingFrequency})
+		if err != nil {
+			return nil, err
+		}
+		return &responseBody, nil
+	}
 	responseBody := new(ResponseT)
 	contentType := resp.Header.Get("Content-Type")
 	switch {
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// newTestClient replaces the shared client with a client of the server, it's restored when the test finishes.
+func newTestClient(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	oldClient, oldFrequency := c, pollingFrequency
+	c = &Client{
+		host: server.URL,
+		pl:   runtime.NewPipeline("aztfpreflight", "dev", runtime.PipelineOptions{}, &policy.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}),
+	}
+	pollingFrequency = 10 * time.Millisecond
+	t.Cleanup(func() {
+		server.Close()
+		c, pollingFrequency = oldClient, oldFrequency
+	})
+}
+
+func Test_Preflight_LongRunningOperation(t *testing.T) {
+	vnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	testcases := []struct {
+		Name        string
+		FinalStatus int
+		FinalBody   string
+		Expect      []string
+		ExpectError string
+	}{
+		{
+			Name:        "succeeded",
+			FinalStatus: http.StatusOK,
+			FinalBody:   `{"properties":{"validatedResources":["` + vnetId + `"]}}`,
+			Expect:      []string{vnetId},
+		},
+		{
+			Name:        "failed",
+			FinalStatus: http.StatusBadRequest,
+			FinalBody:   `{"error":{"code":"InvalidTemplateDeployment","message":"validation failed","details":[{"code":"InvalidAddressPrefix","target":"` + vnetId + `","message":"invalid prefix"}]}}`,
+			ExpectError: "InvalidAddressPrefix",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			var polls atomic.Int32
+			newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPost:
+					w.Header().Set("Location", "http://"+r.Host+"/operations/op")
+					w.WriteHeader(http.StatusAccepted)
+				case http.MethodGet:
+					if polls.Add(1) < 3 {
+						w.WriteHeader(http.StatusAccepted)
+						return
+					}
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(testcase.FinalStatus)
+					_, _ = w.Write([]byte(testcase.FinalBody))
+				}
+			})
+
+			resp, err := Preflight(context.TODO(), PreflightRequestModel{Scope: "/subscriptions/000/resourceGroups/rg"})
+			if testcase.ExpectError != "" {
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", resp)
+				}
+				preflightErrors := parsePreflightErrors(err)
+				if len(preflightErrors) != 1 || preflightErrors[0].Code != testcase.ExpectError || !strings.EqualFold(preflightErrors[0].Target, vnetId) {
+					t.Fatalf("Expected a %s error targeting %s, got %v", testcase.ExpectError, vnetId, preflightErrors)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if polls.Load() != 3 {
+				t.Fatalf("Expected 3 polls, got %d", polls.Load())
+			}
+			if len(resp.Properties.ValidatedResources) != 1 || resp.Properties.ValidatedResources[0] != testcase.Expect[0] {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, resp.Properties.ValidatedResources)
+			}
+		})
+	}
+}
+
+func Test_Preflight_AsyncOperationFailed(t *testing.T) {
+	vnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	var polls atomic.Int32
+	newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Azure-AsyncOperation", "http://"+r.Host+"/operations/op")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if polls.Add(1) < 2 {
+				_, _ = w.Write([]byte(`{"status":"InProgress"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"Failed","error":{"code":"InvalidTemplateDeployment","message":"validation failed","details":[{"code":"InvalidAddressPrefix","target":"` + vnetId + `","message":"invalid prefix"}]}}`))
+		}
+	})
+
+	_, err := Preflight(context.TODO(), PreflightRequestModel{Scope: "/subscriptions/000/resourceGroups/rg"})
+	if err == nil || !isValidationError(err) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	results := []PreflightResult{{ResourceId: vnetId}, {ResourceId: vnetId + "/subnets/subnet"}}
+	attributeErrors(results, []int{0, 1}, nil, err)
+	if results[0].RequestError != "" || len(results[0].Errors) != 1 || results[0].Errors[0].Code != "InvalidAddressPrefix" {
+		t.Fatalf("Expected the InvalidAddressPrefix error of the virtual network, got %v", results[0])
+	}
+	if results[1].RequestError != "" || len(results[1].Errors) != 0 {
+		t.Fatalf("Expected no error for the subnet, got %v", results[1])
+	}
+}
+
+func Test_preflightWithTimeout(t *testing.T) {
+	newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "http://"+r.Host+"/operations/op")
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	_, err := preflightWithTimeout(context.TODO(), PreflightRequestModel{Scope: "/subscriptions/000"}, 100*time.Millisecond)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "didn't finish in 100ms") {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+}
@@ -3,16 +3,26 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/sirupsen/logrus"
 )
 
+const managementGroupResourceType = "Microsoft.Management/managementGroups"
+
 type PreflightRequestModel struct {
 	Provider  string                   `json:"provider"`
 	Type      string                   `json:"type"`
@@ -29,66 +39,494 @@ type PropertiesModel struct {
 	ValidatedResources []string `json:"validatedResources"`
 }
 
-func Preflight(ctx context.Context, model PreflightRequestModel) (interface{}, error) {
+// PreflightResult is the preflight result of a single terraform resource.
+type PreflightResult struct {
+	Address       string           `json:"address"`
+	ModuleAddress string           `json:"moduleAddress,omitempty"`
+	ResourceId    string           `json:"resourceId,omitempty"`
+	Validated     bool             `json:"validated"`
+	Errors        []PreflightError `json:"errors,omitempty"`
+	// RequestError is set when the resource couldn't be validated, for example because of an authentication failure.
+	RequestError string `json:"requestError,omitempty"`
+	// PayloadError is set when the preflight request body couldn't be built from the payload of the resource, it's not sent to the preflight API.
+	PayloadError string `json:"payloadError,omitempty"`
+	// ThrottledRetries is the number of times the preflight request of the resource was throttled and retried.
+	ThrottledRetries int `json:"throttledRetries,omitempty"`
+}
+
+type PreflightError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Target  string `json:"target,omitempty"`
+}
+
+type armErrorResponse struct {
+	Error armErrorDetail `json:"error"`
+}
+
+type armErrorDetail struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Target  string           `json:"target"`
+	Details []armErrorDetail `json:"details"`
+}
+
+func Preflight(ctx context.Context, model PreflightRequestModel) (*PreflightResponseModel, error) {
 	client, err := DefaultSharedClient()
 	if err != nil {
 		return nil, err
 	}
-	resp, err := Execute[PreflightResponseModel](ctx, client, http.MethodPost, "/providers/Microsoft.Resources/validateResources", "2020-10-01", model)
+	resp, err := Execute[PreflightResponseModel](ctx, client, http.MethodPost, preflightEndpoint(model.Scope), "2020-10-01", model)
 	if err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
-func PreflightInBatch(ctx context.Context, requests []types.RequestModel, concurrency int) []error {
+const (
+	DefaultPreflightConcurrency = 8
+	// DefaultMaxBatchSize is the max number of resources in a preflight request.
+	DefaultMaxBatchSize = 50
+	// DefaultMaxRetries is the max number of retries of a throttled preflight request.
+	DefaultMaxRetries = 3
+	// DefaultPreflightTimeout is the default timeout of a preflight request, including the polling of its long-running operation.
+	DefaultPreflightTimeout = 5 * time.Minute
+
+	// baseRetryDelay is the delay before the first retry of a throttled preflight request without a Retry-After header, it's doubled for each retry.
+	baseRetryDelay = 2 * time.Second
+)
+
+// preflight sends a preflight request, it's replaced in the tests.
+var preflight = Preflight
+
+type PreflightOptions struct {
+	// Concurrency is the max number of concurrent preflight requests.
+	Concurrency int
+	// MaxBatchSize is the max number of resources in a preflight request, the larger groups are split into several requests.
+	// The parents of the resources in a split request, like their resource group, are sent with them and aren't counted.
+	MaxBatchSize int
+	// MaxRetries is the max number of retries of a throttled preflight request.
+	MaxRetries int
+	// Timeout is the max duration of a preflight request, including the polling of its long-running operation.
+	Timeout time.Duration
+}
+
+// preflightBatch is a preflight request and the indices of the results of its resources, in the order of the resources.
+type preflightBatch struct {
+	request PreflightRequestModel
+	indices []int
+	// parents is the indices of the results of the parents at the start of the request, which are validated in another batch.
+	// They're sent so the preflight API sees the new parents of the resources, but the batch doesn't record their results.
+	parents []int
+}
+
+// PreflightInBatch validates the requests in groups and returns one result per request, in the same order as the input.
+// A group is split into batches of at most MaxBatchSize resources. A batch which fails without an error targeting its resources
+// is bisected until the resources which fail it are found, and the throttled batches are retried with backoff.
+func PreflightInBatch(ctx context.Context, requests []types.RequestModel, options PreflightOptions) []PreflightResult {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultPreflightTimeout
+	}
+
+	results := make([]PreflightResult, len(requests))
 	preflightRequests := make([]PreflightRequestModel, 0, len(requests))
-	preflightErrors := make([]error, 0)
-	for _, req := range requests {
+	preflightIndices := make([]int, 0, len(requests))
+	for i, req := range requests {
+		results[i] = PreflightResult{
+			Address:       req.Address,
+			ModuleAddress: req.ModuleAddress,
+			ResourceId:    req.ResourceId,
+		}
+		if results[i].ResourceId == "" {
+			results[i].ResourceId = resourceIdFromUrl(req.URL)
+		}
 		preflightRequest, err := BuildPreflightRequestBody(req)
 		if err != nil {
-			preflightErrors = append(preflightErrors, err)
+			results[i].PayloadError = err.Error()
 			continue
 		}
 		preflightRequests = append(preflightRequests, preflightRequest)
+		preflightIndices = append(preflightIndices, i)
 	}
 
-	// group the requests by provider, type, location, scope
-	groupedRequests := make(map[string]*PreflightRequestModel)
-	for _, r := range preflightRequests {
-		key := preflightRequestKey(r)
-		if existing, ok := groupedRequests[key]; ok {
-			existing.Resources = append(existing.Resources, r.Resources...)
-			groupedRequests[key] = existing
-		} else {
-			groupedRequests[key] = &r
+	resourceIds := make([]string, len(preflightIndices))
+	for i, index := range preflightIndices {
+		resourceIds[i] = results[index].ResourceId
+	}
+	groupedRequests, groupedPositions := groupPreflightRequests(preflightRequests, resourceIds)
+	groupedIndices := make(map[string][]int)
+	for key, positions := range groupedPositions {
+		for _, position := range positions {
+			groupedIndices[key] = append(groupedIndices[key], preflightIndices[position])
 		}
 	}
 	logrus.Debugf("Grouped %d requests into %d preflight requests", len(preflightRequests), len(groupedRequests))
 
-	sem := make(chan struct{}, concurrency)
-	var mu = &sync.Mutex{}
-	var wg sync.WaitGroup
-	for _, r := range groupedRequests {
+	batches := make([]preflightBatch, 0, len(groupedRequests))
+	for key, r := range groupedRequests {
 		if r == nil {
 			continue
 		}
-		r := r // capture loop variable
+		batches = append(batches, splitPreflightRequest(preflightBatch{request: *r, indices: groupedIndices[key]}, results, options.MaxBatchSize)...)
+	}
+	logrus.Debugf("Split %d preflight requests into %d batches of at most %d resources", len(groupedRequests), len(batches), options.MaxBatchSize)
+
+	sem := make(chan struct{}, options.Concurrency)
+	var mu = &sync.Mutex{}
+	var wg sync.WaitGroup
+	for _, batch := range batches {
 		wg.Add(1)
 		sem <- struct{}{}
 		go func() {
 			defer wg.Done()
 			defer func() { <-sem }()
-			if _, err := Preflight(ctx, *r); err != nil {
-				mu.Lock()
-				preflightErrors = append(preflightErrors, err)
-				mu.Unlock()
-			}
+			validateBatch(ctx, batch, results, mu, options)
 		}()
 	}
 
 	wg.Wait()
-	return preflightErrors
+	return results
+}
+
+// splitPreflightRequest splits the batch into batches of at most maxBatchSize resources, the order of the resources is kept.
+// The parents of the resources in each batch, like the virtual network of a subnet or a resource group created in the same plan,
+// are added at the start of the batch when they're in another batch, so the preflight API doesn't report them as not found.
+func splitPreflightRequest(batch preflightBatch, results []PreflightResult, maxBatchSize int) []preflightBatch {
+	// the resources of the batch are its parents followed by its own resources
+	entries := append(append(make([]int, 0, len(batch.parents)+len(batch.indices)), batch.parents...), batch.indices...)
+	positions := make(map[string]int)
+	for position, index := range entries {
+		positions[strings.ToLower(results[index].ResourceId)] = position
+	}
+
+	out := make([]preflightBatch, 0, (len(batch.indices)+maxBatchSize-1)/maxBatchSize)
+	for start := len(batch.parents); start < len(entries); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(entries))
+		parentPositions := make([]int, 0)
+		for _, index := range entries[start:end] {
+			armId, err := arm.ParseResourceID(results[index].ResourceId)
+			if err != nil {
+				continue
+			}
+			for parent := armId.Parent; parent != nil; parent = parent.Parent {
+				if position, ok := positions[strings.ToLower(parent.String())]; ok && (position < start || position >= end) && !slices.Contains(parentPositions, position) {
+					parentPositions = append(parentPositions, position)
+				}
+			}
+		}
+		// the parents are kept in the order of the batch, which has the parents before their children
+		slices.Sort(parentPositions)
+
+		part := preflightBatch{
+			request: batch.request,
+			indices: entries[start:end:end],
+			parents: make([]int, 0, len(parentPositions)),
+		}
+		part.request.Resources = make([]map[string]interface{}, 0, len(parentPositions)+end-start)
+		for _, position := range parentPositions {
+			part.parents = append(part.parents, entries[position])
+			part.request.Resources = append(part.request.Resources, batch.request.Resources[position])
+		}
+		part.request.Resources = append(part.request.Resources, batch.request.Resources[start:end]...)
+		out = append(out, part)
+	}
+	return out
+}
+
+// validateBatch validates the batch and records the results of its resources. When the batch fails with errors which don't target any of its resources,
+// like a request which is too large or malformed, it's bisected and each half is validated on its own.
+func validateBatch(ctx context.Context, batch preflightBatch, results []PreflightResult, mu *sync.Mutex, options PreflightOptions) {
+	resp, retries, err := preflightWithRetry(ctx, batch.request, options)
+	mu.Lock()
+	for _, index := range batch.indices {
+		results[index].ThrottledRetries += retries
+	}
+	if err == nil {
+		markValidated(results, batch.indices, resp)
+		mu.Unlock()
+		return
+	}
+	if len(batch.indices) > 1 && isValidationError(err) && !targetsAnyResource(parsePreflightErrors(err), results, slices.Concat(batch.parents, batch.indices)) {
+		mu.Unlock()
+		logrus.Debugf("preflight request of %d resources failed without a target, bisecting it: %v", len(batch.indices), err)
+		half := len(batch.indices) / 2
+		for _, part := range splitPreflightRequest(batch, results, half) {
+			validateBatch(ctx, part, results, mu, options)
+		}
+		return
+	}
+	attributeErrors(results, batch.indices, batch.parents, err)
+	mu.Unlock()
+}
+
+// preflightWithRetry sends the preflight request, and retries it with backoff when it's throttled. The number of retries is returned.
+// Each attempt, including the polling of its long-running operation, must finish within the timeout of the options.
+func preflightWithRetry(ctx context.Context, model PreflightRequestModel, options PreflightOptions) (*PreflightResponseModel, int, error) {
+	for retries := 0; ; retries++ {
+		resp, err := preflightWithTimeout(ctx, model, options.Timeout)
+		delay, throttled := throttlingDelay(err, retries)
+		if !throttled || retries >= options.MaxRetries {
+			return resp, retries, err
+		}
+		logrus.Warnf("preflight request of %d resources is throttled, retrying in %s", len(model.Resources), delay)
+		select {
+		case <-ctx.Done():
+			return nil, retries, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func preflightWithTimeout(ctx context.Context, model PreflightRequestModel, timeout time.Duration) (*PreflightResponseModel, error) {
+	if timeout <= 0 {
+		return preflight(ctx, model)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := preflight(timeoutCtx, model)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("the preflight validation didn't finish in %s: %w", timeout, err)
+	}
+	return resp, err
+}
+
+// throttlingDelay reports whether the error is a throttling response, and returns the delay before the next retry.
+// The delay is taken from the Retry-After header, or it's an exponential backoff if the header is missing.
+func throttlingDelay(err error, retries int) (time.Duration, bool) {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if respErr.RawResponse != nil {
+		if value := respErr.RawResponse.Header.Get("Retry-After"); value != "" {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+			if retryTime, err := http.ParseTime(value); err == nil {
+				return max(time.Until(retryTime), 0), true
+			}
+		}
+	}
+	return baseRetryDelay << retries, true
+}
+
+// targetsAnyResource reports whether any of the errors targets one of the resources.
+func targetsAnyResource(preflightErrors []PreflightError, results []PreflightResult, indices []int) bool {
+	for _, preflightError := range preflightErrors {
+		for _, index := range indices {
+			if targetsResource(preflightError, results[index].ResourceId) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func targetsResource(preflightError PreflightError, resourceId string) bool {
+	return preflightError.Target != "" && strings.EqualFold(resourceId, strings.TrimSuffix(preflightError.Target, "/"))
+}
+
+// attributeErrors matches the error details of a failed preflight request to the resources in the group by their resource IDs.
+// Errors that can't be matched to a resource are attached to all resources in the group.
+// The errors of the parents, which are sent with the resources but validated in another request, are skipped.
+func attributeErrors(results []PreflightResult, indices []int, parents []int, err error) {
+	if !isValidationError(err) {
+		for _, index := range indices {
+			results[index].RequestError = err.Error()
+		}
+		return
+	}
+	preflightErrors := parsePreflightErrors(err)
+	for _, preflightError := range preflightErrors {
+		if targetsAnyResource([]PreflightError{preflightError}, results, parents) {
+			continue
+		}
+		matched := false
+		if preflightError.Target != "" {
+			for _, index := range indices {
+				if targetsResource(preflightError, results[index].ResourceId) {
+					results[index].Errors = append(results[index].Errors, preflightError)
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			for _, index := range indices {
+				results[index].Errors = append(results[index].Errors, preflightError)
+			}
+		}
+	}
+}
+
+// isValidationError reports whether the error is a validation result of the preflight API,
+// rather than an error that stopped the validation from running, like authentication, throttling or server errors.
+// A validation whose long-running operation fails returns the status of the operation with a successful status code,
+// like 200 with `{"status":"Failed","error":{...}}` from its Azure-AsyncOperation URL.
+func isValidationError(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	if respErr.StatusCode >= http.StatusOK && respErr.StatusCode < http.StatusMultipleChoices {
+		return true
+	}
+	switch respErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return respErr.StatusCode >= http.StatusBadRequest && respErr.StatusCode < http.StatusInternalServerError
+}
+
+// parsePreflightErrors flattens the ARM error returned by the preflight API into a list of errors.
+// Only the innermost details are returned, because the outer errors, like ResourceValidationFailed, only summarize them.
+func parsePreflightErrors(err error) []PreflightError {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.RawResponse == nil {
+		return []PreflightError{{Message: err.Error()}}
+	}
+	payload, payloadErr := runtime.Payload(respErr.RawResponse)
+	if payloadErr != nil {
+		return []PreflightError{{Code: respErr.ErrorCode, Message: err.Error()}}
+	}
+	var errorResponse armErrorResponse
+	if jsonErr := json.Unmarshal(payload, &errorResponse); jsonErr != nil || errorResponse.Error.Code == "" {
+		return []PreflightError{{Code: respErr.ErrorCode, Message: err.Error()}}
+	}
+	return flattenErrorDetail(errorResponse.Error)
+}
+
+func flattenErrorDetail(detail armErrorDetail) []PreflightError {
+	if len(detail.Details) == 0 {
+		return []PreflightError{{
+			Code:    detail.Code,
+			Message: detail.Message,
+			Target:  detail.Target,
+		}}
+	}
+	out := make([]PreflightError, 0)
+	for _, inner := range detail.Details {
+		if inner.Target == "" {
+			inner.Target = detail.Target
+		}
+		out = append(out, flattenErrorDetail(inner)...)
+	}
+	return out
+}
+
+func markValidated(results []PreflightResult, indices []int, resp *PreflightResponseModel) {
+	if resp == nil || len(resp.Properties.ValidatedResources) == 0 {
+		for _, index := range indices {
+			results[index].Validated = true
+		}
+		return
+	}
+	for _, index := range indices {
+		for _, validatedResource := range resp.Properties.ValidatedResources {
+			if strings.EqualFold(results[index].ResourceId, strings.TrimSuffix(validatedResource, "/")) {
+				results[index].Validated = true
+				break
+			}
+		}
+	}
+}
+
+// groupPreflightRequests groups the preflight requests by provider, type, location and scope, and returns the positions of the requests in each group.
+// The child resources are validated in the same request as their top-most parent in the batch, with its location and scope,
+// so the preflight API sees the new parents of the children. The parents are added before their children.
+// When the top-most parent is a resource group, which is created in the same plan, its resources are validated with it at the subscription scope,
+// and each of them is sent with the name of its resource group.
+func groupPreflightRequests(preflightRequests []PreflightRequestModel, resourceIds []string) (map[string]*PreflightRequestModel, map[string][]int) {
+	roots := preflightRoots(resourceIds)
+	order := make([]int, len(preflightRequests))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return resourceIdDepth(resourceIds[order[a]]) < resourceIdDepth(resourceIds[order[b]])
+	})
+
+	groupedRequests := make(map[string]*PreflightRequestModel)
+	groupedPositions := make(map[string][]int)
+	for _, i := range order {
+		r := preflightRequests[i]
+		if roots[i] != i && isResourceGroupId(resourceIds[roots[i]]) {
+			if armId, err := arm.ParseResourceID(resourceIds[i]); err == nil && armId.ResourceGroupName != "" {
+				r.Resources[0]["resourceGroup"] = armId.ResourceGroupName
+			}
+		}
+		key := preflightRequestKey(preflightRequests[roots[i]])
+		if existing, ok := groupedRequests[key]; ok {
+			existing.Resources = append(existing.Resources, r.Resources...)
+		} else {
+			groupedRequests[key] = &r
+		}
+		groupedPositions[key] = append(groupedPositions[key], i)
+	}
+	return groupedRequests, groupedPositions
+}
+
+func isResourceGroupId(resourceId string) bool {
+	armId, err := arm.ParseResourceID(resourceId)
+	return err == nil && strings.EqualFold(armId.ResourceType.String(), arm.ResourceGroupResourceType.String())
+}
+
+// preflightRoots returns the position of the top-most parent of each resource in the batch, which is itself if it has no parent in the batch.
+// The parents are the resources whose IDs are the prefixes of the resource ID, like the virtual network or the resource group of a subnet.
+// The resource extended by an extension resource and its parents, other than the deployment scopes, aren't parents of the extension resource,
+// so a role assignment on a storage account is grouped on its own type, and with a resource group created in the same plan.
+func preflightRoots(resourceIds []string) []int {
+	positions := make(map[string]int)
+	for i, resourceId := range resourceIds {
+		positions[strings.ToLower(resourceId)] = i
+	}
+	out := make([]int, len(resourceIds))
+	for i, resourceId := range resourceIds {
+		out[i] = i
+		armId, err := arm.ParseResourceID(resourceId)
+		if err != nil {
+			continue
+		}
+		_, extendedResourceId := preflightScope(armId)
+		for parent := armId.Parent; parent != nil; parent = parent.Parent {
+			if extendedResourceId != "" && len(parent.String()) <= len(extendedResourceId) && !isDeploymentScope(parent) {
+				continue
+			}
+			if position, ok := positions[strings.ToLower(parent.String())]; ok {
+				out[i] = position
+			}
+		}
+	}
+	return out
+}
+
+func resourceIdDepth(resourceId string) int {
+	return strings.Count(strings.Trim(resourceId, "/"), "/")
+}
+
+// templateName returns the name of the resource in the ARM template format, the names of the child resources are prefixed with the names of their parents, like `vnet/subnet`.
+func templateName(armId *arm.ResourceID) string {
+	names := make([]string, 0, len(armId.ResourceType.Types))
+	for id := armId; id != nil && len(names) < len(armId.ResourceType.Types); id = id.Parent {
+		names = append([]string{id.Name}, names...)
+	}
+	return strings.Join(names, "/")
+}
+
+func resourceIdFromUrl(input string) string {
+	parsedUrl, err := url.Parse(input)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(parsedUrl.Path, "/")
 }
 
 func preflightRequestKey(r PreflightRequestModel) string {
@@ -96,6 +534,9 @@ func preflightRequestKey(r PreflightRequestModel) string {
 }
 
 func BuildPreflightRequestBody(request types.RequestModel) (PreflightRequestModel, error) {
+	if reason := request.PreflightSkipReason(); reason != "" {
+		return PreflightRequestModel{}, errors.New(reason)
+	}
 	parsedUrl, err := url.Parse(request.URL)
 	if err != nil {
 		return PreflightRequestModel{}, err
@@ -107,7 +548,7 @@ func BuildPreflightRequestBody(request types.RequestModel) (PreflightRequestMode
 	}
 
 	var payloadMap map[string]interface{}
-	if err := json.Unmarshal([]byte(request.Body), &payloadMap); err != nil {
+	if err := json.Unmarshal([]byte(request.PreflightBody()), &payloadMap); err != nil {
 		return PreflightRequestModel{}, err
 	}
 
@@ -116,20 +557,22 @@ func BuildPreflightRequestBody(request types.RequestModel) (PreflightRequestMode
 		location = loc.(string)
 	}
 
-	scopeId := armId.Parent
-	for scopeId.Parent != nil && scopeId.ResourceType.String() != arm.SubscriptionResourceType.String() &&
-		scopeId.ResourceType.String() != arm.ResourceGroupResourceType.String() &&
-		scopeId.ResourceType.String() != arm.TenantResourceType.String() {
-		scopeId = scopeId.Parent
+	scope, extendedResourceId := preflightScope(armId)
+	if extendedResourceId != "" {
+		payloadMap["scope"] = extendedResourceId
 	}
 
-	payloadMap["apiVersion"] = parsedUrl.Query().Get("api-version")
-	payloadMap["name"] = armId.Name
+	payloadMap["apiVersion"] = request.APIVersion
+	if request.APIVersion == "" {
+		payloadMap["apiVersion"] = parsedUrl.Query().Get("api-version")
+	}
+	payloadMap["name"] = templateName(armId)
+	payloadMap["type"] = armId.ResourceType.String()
 	preflightRequestModel := PreflightRequestModel{
 		Provider: armId.ResourceType.Namespace,
 		Type:     armId.ResourceType.Type,
 		Location: normalizeLocation(location),
-		Scope:    scopeId.String(),
+		Scope:    scope,
 		Resources: []map[string]interface{}{
 			payloadMap,
 		},
@@ -137,6 +580,41 @@ func BuildPreflightRequestBody(request types.RequestModel) (PreflightRequestMode
 	return preflightRequestModel, nil
 }
 
+// preflightScope returns the deployment scope of the resource, which is the tenant `/`, a management group, a subscription or a resource group.
+// The resource ID of the extended resource is returned too if the resource is an extension resource, like a role assignment on a storage account.
+func preflightScope(armId *arm.ResourceID) (string, string) {
+	// the scope of a resource is the parent of its top-level resource, the resource type of a child resource holds the types of its parents
+	scopeId := armId
+	for i := 0; i < len(armId.ResourceType.Types) && scopeId.Parent != nil; i++ {
+		scopeId = scopeId.Parent
+	}
+	extendedResourceId := ""
+	if !isDeploymentScope(scopeId) {
+		extendedResourceId = scopeId.String()
+		for scopeId.Parent != nil && !isDeploymentScope(scopeId) {
+			scopeId = scopeId.Parent
+		}
+	}
+	if scopeId.String() == "" {
+		return "/", extendedResourceId
+	}
+	return scopeId.String(), extendedResourceId
+}
+
+func isDeploymentScope(armId *arm.ResourceID) bool {
+	for _, resourceType := range []string{arm.TenantResourceType.String(), arm.SubscriptionResourceType.String(), arm.ResourceGroupResourceType.String(), managementGroupResourceType} {
+		if strings.EqualFold(armId.ResourceType.String(), resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightEndpoint returns the path of the preflight API at the deployment scope, like `/subscriptions/000/resourceGroups/rg/providers/Microsoft.Resources/validateResources`.
+func preflightEndpoint(scope string) string {
+	return strings.TrimSuffix(scope, "/") + "/providers/Microsoft.Resources/validateResources"
+}
+
 func normalizeLocation(input string) string {
 	return strings.ReplaceAll(strings.ToLower(input), " ", "")
 }
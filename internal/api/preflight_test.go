@@ -3,11 +3,17 @@ package api
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 )
 
 func Test_Preflight(t *testing.T) {
@@ -150,19 +156,41 @@ func Test_PreflightInBatch(t *testing.T) {
 	}
 
 	for _, tc := range testcases {
-		errs := PreflightInBatch(context.TODO(), tc.requests, 5)
-		if len(errs) != tc.expectedErrLen {
-			t.Fatalf("expected %d errors, got %d", tc.expectedErrLen, len(errs))
+		results := PreflightInBatch(context.TODO(), tc.requests, PreflightOptions{Concurrency: 5})
+		if len(results) != len(tc.requests) {
+			t.Fatalf("expected %d results, got %d", len(tc.requests), len(results))
 		}
-		for _, err := range errs {
-			if err != nil {
-				t.Fatalf("unexpected error: %v", err)
+		errs := make([]PreflightError, 0)
+		for _, result := range results {
+			if result.RequestError != "" {
+				t.Fatalf("unexpected request error: %s", result.RequestError)
 			}
+			errs = append(errs, result.Errors...)
+		}
+		if len(errs) != tc.expectedErrLen {
+			t.Fatalf("expected %d errors, got %d: %v", tc.expectedErrLen, len(errs), errs)
 		}
 	}
 
 }
 
+func Test_PreflightInBatch_PayloadError(t *testing.T) {
+	requests := []types.RequestModel{
+		{
+			Address: "azurerm_resource_group.test",
+			URL:     "https://management.azure.com/subscriptions/000/resourceGroups/rg?api-version=2021-04-01",
+			Body:    `{"location":`,
+		},
+	}
+	results := PreflightInBatch(context.TODO(), requests, PreflightOptions{})
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].PayloadError == "" || results[0].RequestError != "" {
+		t.Fatalf("expected a payload error and no request error, got %+v", results[0])
+	}
+}
+
 func Test_preflightRequestKey(t *testing.T) {
 	testcases := []struct {
 		r        PreflightRequestModel
@@ -182,6 +210,191 @@ func Test_preflightRequestKey(t *testing.T) {
 	}
 }
 
+func Test_preflightRoots(t *testing.T) {
+	vnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	accountId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"
+	resourceIds := []string{
+		vnetId + "/subnets/subnet",
+		vnetId,
+		"/subscriptions/000/resourceGroups/other/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet",
+		accountId,
+		accountId + "/providers/Microsoft.Authorization/roleAssignments/ra",
+		"/subscriptions/000/resourceGroups/other",
+		"/subscriptions/000/resourceGroups/other/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet/providers/Microsoft.Authorization/locks/lock",
+	}
+
+	// the extension resources aren't grouped with the resource they extend, only with a resource group in the batch
+	expected := []int{1, 1, 5, 3, 4, 5, 5}
+	actual := preflightRoots(resourceIds)
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected roots %v, got %v", expected, actual)
+		}
+	}
+}
+
+func Test_groupPreflightRequests(t *testing.T) {
+	requests := []types.RequestModel{
+		{
+			URL:  "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet?api-version=2023-04-01",
+			Body: `{"properties":{"addressPrefix":"10.0.0.0/24"}}`,
+		},
+		{
+			URL:  "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			Body: `{"location":"westeurope"}`,
+		},
+		{
+			URL:  "https://management.azure.com/subscriptions/000/resourcegroups/rg?api-version=2020-06-01",
+			Body: `{"location":"westeurope"}`,
+		},
+		{
+			URL:  "https://management.azure.com/subscriptions/000/resourceGroups/existing/providers/Microsoft.Storage/storageAccounts/sa?api-version=2023-01-01",
+			Body: `{"location":"westeurope"}`,
+		},
+		{
+			URL:  "https://management.azure.com/subscriptions/000/resourceGroups/existing/providers/Microsoft.Storage/storageAccounts/sa/providers/Microsoft.Authorization/roleAssignments/ra?api-version=2022-04-01",
+			Body: `{"properties":{"roleDefinitionId":"/subscriptions/000/providers/Microsoft.Authorization/roleDefinitions/rd"}}`,
+		},
+	}
+	preflightRequests := make([]PreflightRequestModel, 0, len(requests))
+	resourceIds := make([]string, 0, len(requests))
+	for _, request := range requests {
+		preflightRequest, err := BuildPreflightRequestBody(request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		preflightRequests = append(preflightRequests, preflightRequest)
+		resourceIds = append(resourceIds, resourceIdFromUrl(request.URL))
+	}
+
+	groupedRequests, groupedPositions := groupPreflightRequests(preflightRequests, resourceIds)
+	if len(groupedRequests) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(groupedRequests))
+	}
+
+	// the new resource group and its resources are validated at the subscription scope, the parents first
+	key := "Microsoft.Resources|resourceGroups|westeurope|/subscriptions/000"
+	group := groupedRequests[key]
+	if group == nil {
+		t.Fatalf("expected the group %q, got %v", key, groupedRequests)
+	}
+	if positions := fmt.Sprint(groupedPositions[key]); positions != "[2 1 0]" {
+		t.Fatalf("expected the positions [2 1 0], got %s", positions)
+	}
+	expectedNames := []string{"rg", "vnet", "vnet/subnet"}
+	expectedResourceGroups := []interface{}{nil, "rg", "rg"}
+	for i, resource := range group.Resources {
+		if resource["name"] != expectedNames[i] || resource["resourceGroup"] != expectedResourceGroups[i] {
+			t.Fatalf("expected the resource %s in %v, got %v", expectedNames[i], expectedResourceGroups[i], resource)
+		}
+	}
+
+	// the resources of the existing resource groups are still validated at the resource group scope
+	key = "Microsoft.Storage|storageAccounts|westeurope|/subscriptions/000/resourceGroups/existing"
+	if group := groupedRequests[key]; group == nil || group.Resources[0]["resourceGroup"] != nil {
+		t.Fatalf("expected the group %q without a resource group, got %v", key, groupedRequests)
+	}
+
+	// the extension resources are grouped on their own type, not on the type of the resource they extend
+	key = "Microsoft.Authorization|roleAssignments||/subscriptions/000/resourceGroups/existing"
+	if positions := fmt.Sprint(groupedPositions[key]); positions != "[4]" {
+		t.Fatalf("expected the group %q with the positions [4], got %v", key, groupedPositions)
+	}
+}
+
+func Test_BuildPreflightRequestBody_ChildResource(t *testing.T) {
+	req := types.RequestModel{
+		URL:  "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet?api-version=2023-04-01",
+		Body: `{"properties":{"addressPrefix":"10.0.0.0/24"}}`,
+	}
+	body, err := BuildPreflightRequestBody(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name := body.Resources[0]["name"]; name != "vnet/subnet" {
+		t.Fatalf("expected name vnet/subnet, got %v", name)
+	}
+	if resourceType := body.Resources[0]["type"]; resourceType != "Microsoft.Network/virtualNetworks/subnets" {
+		t.Fatalf("expected type Microsoft.Network/virtualNetworks/subnets, got %v", resourceType)
+	}
+}
+
+func Test_BuildPreflightRequestBody_Scopes(t *testing.T) {
+	accountId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"
+	testcases := []struct {
+		resourceId       string
+		expectedProvider string
+		expectedType     string
+		expectedScope    string
+		expectedExtended interface{}
+		expectedEndpoint string
+	}{
+		{
+			resourceId:       "/providers/Microsoft.Management/managementGroups/mg/providers/Microsoft.Authorization/policyDefinitions/pd",
+			expectedProvider: "Microsoft.Authorization",
+			expectedType:     "policyDefinitions",
+			expectedScope:    "/providers/Microsoft.Management/managementGroups/mg",
+			expectedEndpoint: "/providers/Microsoft.Management/managementGroups/mg/providers/Microsoft.Resources/validateResources",
+		},
+		{
+			resourceId:       "/providers/Microsoft.Management/managementGroups/mg",
+			expectedProvider: "Microsoft.Management",
+			expectedType:     "managementGroups",
+			expectedScope:    "/",
+			expectedEndpoint: "/providers/Microsoft.Resources/validateResources",
+		},
+		{
+			resourceId:       "/subscriptions/000/providers/Microsoft.Authorization/policyAssignments/pa",
+			expectedProvider: "Microsoft.Authorization",
+			expectedType:     "policyAssignments",
+			expectedScope:    "/subscriptions/000",
+			expectedEndpoint: "/subscriptions/000/providers/Microsoft.Resources/validateResources",
+		},
+		{
+			resourceId:       accountId + "/providers/Microsoft.Authorization/roleAssignments/ra",
+			expectedProvider: "Microsoft.Authorization",
+			expectedType:     "roleAssignments",
+			expectedScope:    "/subscriptions/000/resourceGroups/rg",
+			expectedExtended: accountId,
+			expectedEndpoint: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Resources/validateResources",
+		},
+		{
+			resourceId:       accountId + "/blobServices/default/providers/Microsoft.Insights/diagnosticSettings/ds",
+			expectedProvider: "Microsoft.Insights",
+			expectedType:     "diagnosticSettings",
+			expectedScope:    "/subscriptions/000/resourceGroups/rg",
+			expectedExtended: accountId + "/blobServices/default",
+			expectedEndpoint: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Resources/validateResources",
+		},
+		{
+			resourceId:       "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Authorization/locks/lock",
+			expectedProvider: "Microsoft.Authorization",
+			expectedType:     "locks",
+			expectedScope:    "/subscriptions/000/resourceGroups/rg",
+			expectedEndpoint: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Resources/validateResources",
+		},
+	}
+
+	for _, tc := range testcases {
+		body, err := BuildPreflightRequestBody(types.RequestModel{
+			URL:  "https://management.azure.com" + tc.resourceId + "?api-version=2022-06-01",
+			Body: `{"properties":{}}`,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tc.resourceId, err)
+		}
+		if body.Provider != tc.expectedProvider || body.Type != tc.expectedType || body.Scope != tc.expectedScope {
+			t.Fatalf("expected %s/%s at %s for %s, got %s/%s at %s", tc.expectedProvider, tc.expectedType, tc.expectedScope, tc.resourceId, body.Provider, body.Type, body.Scope)
+		}
+		if extended := body.Resources[0]["scope"]; extended != tc.expectedExtended {
+			t.Fatalf("expected the extended resource %v for %s, got %v", tc.expectedExtended, tc.resourceId, extended)
+		}
+		if endpoint := preflightEndpoint(body.Scope); endpoint != tc.expectedEndpoint {
+			t.Fatalf("expected the endpoint %s for %s, got %s", tc.expectedEndpoint, tc.resourceId, endpoint)
+		}
+	}
+}
+
 func Test_normalizeLocation(t *testing.T) {
 	testcases := []struct {
 		input string
@@ -224,3 +437,287 @@ func Test_BuildPreflightRequestBody(t *testing.T) {
 		t.Fatalf("expected apiVersion propagated, got %v", body.Resources[0]["apiVersion"])
 	}
 }
+
+func Test_attributeErrors(t *testing.T) {
+	body := `{
+  "error": {
+    "code": "ResourceValidationFailed",
+    "message": "Resource validation failed, see details for more information.",
+    "details": [
+      {
+        "code": "InvalidAddressPrefixFormat",
+        "target": "/subscriptions/000000/resourceGroups/example-resources/providers/Microsoft.Network/virtualNetworks/example-network",
+        "message": "Address prefix 10.0.0.0/160 is not formatted correctly.",
+        "details": []
+      },
+      {
+        "code": "InternalServerError",
+        "message": "Something went wrong.",
+        "details": []
+      }
+    ]
+  }
+}`
+	err := &azcore.ResponseError{
+		ErrorCode:  "ResourceValidationFailed",
+		StatusCode: http.StatusBadRequest,
+		RawResponse: &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		},
+	}
+
+	results := []PreflightResult{
+		{
+			Address:    "azurerm_virtual_network.example",
+			ResourceId: "/subscriptions/000000/resourceGroups/example-resources/providers/Microsoft.Network/virtualNetworks/example-network",
+		},
+		{
+			Address:    "module.spoke.azurerm_virtual_network.example",
+			ResourceId: "/subscriptions/000000/resourceGroups/example-resources/providers/Microsoft.Network/virtualNetworks/spoke-network",
+		},
+	}
+	attributeErrors(results, []int{0, 1}, nil, err)
+
+	if len(results[0].Errors) != 2 {
+		t.Fatalf("expected 2 errors for %s, got %v", results[0].Address, results[0].Errors)
+	}
+	if results[0].Errors[0].Code != "InvalidAddressPrefixFormat" {
+		t.Fatalf("expected error code InvalidAddressPrefixFormat, got %s", results[0].Errors[0].Code)
+	}
+	if len(results[1].Errors) != 1 || results[1].Errors[0].Code != "InternalServerError" {
+		t.Fatalf("expected only the untargeted error for %s, got %v", results[1].Address, results[1].Errors)
+	}
+}
+
+func Test_attributeErrors_RequestError(t *testing.T) {
+	err := &azcore.ResponseError{
+		ErrorCode:  "AuthorizationFailed",
+		StatusCode: http.StatusForbidden,
+		RawResponse: &http.Response{
+			StatusCode: http.StatusForbidden,
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"AuthorizationFailed","message":"no access"}}`)),
+		},
+	}
+	results := []PreflightResult{{Address: "azurerm_resource_group.test"}}
+	attributeErrors(results, []int{0}, nil, err)
+	if results[0].RequestError == "" {
+		t.Fatalf("expected request error for authorization failure")
+	}
+	if len(results[0].Errors) != 0 {
+		t.Fatalf("expected no validation errors, got %v", results[0].Errors)
+	}
+}
+
+func Test_markValidated(t *testing.T) {
+	results := []PreflightResult{
+		{ResourceId: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa1"},
+		{ResourceId: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa2"},
+	}
+	markValidated(results, []int{0, 1}, &PreflightResponseModel{
+		Properties: PropertiesModel{
+			ValidatedResources: []string{"/subscriptions/000/resourceGroups/RG/providers/Microsoft.Storage/storageAccounts/sa1"},
+		},
+	})
+	if !results[0].Validated {
+		t.Fatalf("expected %s to be validated", results[0].ResourceId)
+	}
+	if results[1].Validated {
+		t.Fatalf("expected %s not to be validated", results[1].ResourceId)
+	}
+}
+
+func Test_splitPreflightRequest(t *testing.T) {
+	request := PreflightRequestModel{Provider: "Microsoft.Storage", Type: "storageAccounts"}
+	results := make([]PreflightResult, 5)
+	for i := range results {
+		request.Resources = append(request.Resources, map[string]interface{}{"name": fmt.Sprintf("sa%d", i)})
+		results[i].ResourceId = fmt.Sprintf("/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa%d", i)
+	}
+	batches := splitPreflightRequest(preflightBatch{request: request, indices: []int{0, 1, 2, 3, 4}}, results, 2)
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(batches))
+	}
+	for i, batch := range batches {
+		if batch.request.Provider != "Microsoft.Storage" || len(batch.request.Resources) != len(batch.indices) || len(batch.parents) != 0 {
+			t.Fatalf("unexpected batch %d: %v", i, batch)
+		}
+		if name := batch.request.Resources[0]["name"]; name != fmt.Sprintf("sa%d", batch.indices[0]) {
+			t.Fatalf("expected the resource of index %d first in batch %d, got %v", batch.indices[0], i, name)
+		}
+	}
+}
+
+func Test_splitPreflightRequest_Parents(t *testing.T) {
+	rgId := "/subscriptions/000/resourceGroups/rg"
+	vnetId := rgId + "/providers/Microsoft.Network/virtualNetworks/vnet"
+	resourceIds := []string{rgId, vnetId}
+	for i := 0; i < 4; i++ {
+		resourceIds = append(resourceIds, fmt.Sprintf("%s/subnets/subnet%d", vnetId, i))
+	}
+	request := PreflightRequestModel{Scope: "/subscriptions/000"}
+	results := make([]PreflightResult, len(resourceIds))
+	indices := make([]int, len(resourceIds))
+	for i, resourceId := range resourceIds {
+		request.Resources = append(request.Resources, map[string]interface{}{"id": resourceId})
+		results[i].ResourceId = resourceId
+		indices[i] = i
+	}
+
+	// the group of the resource group is larger than the max batch size, every batch must hold the parents of its resources
+	batches := splitPreflightRequest(preflightBatch{request: request, indices: indices}, results, 2)
+	expect := []struct {
+		parents   []int
+		indices   []int
+		resources []string
+	}{
+		{parents: []int{}, indices: []int{0, 1}, resources: []string{rgId, vnetId}},
+		{parents: []int{0, 1}, indices: []int{2, 3}, resources: []string{rgId, vnetId, resourceIds[2], resourceIds[3]}},
+		{parents: []int{0, 1}, indices: []int{4, 5}, resources: []string{rgId, vnetId, resourceIds[4], resourceIds[5]}},
+	}
+	if len(batches) != len(expect) {
+		t.Fatalf("expected %d batches, got %d", len(expect), len(batches))
+	}
+	for i, batch := range batches {
+		resources := make([]string, 0, len(batch.request.Resources))
+		for _, resource := range batch.request.Resources {
+			resources = append(resources, resource["id"].(string))
+		}
+		if !reflect.DeepEqual(batch.parents, expect[i].parents) || !reflect.DeepEqual(batch.indices, expect[i].indices) || !reflect.DeepEqual(resources, expect[i].resources) {
+			t.Fatalf("expected batch %d to be %v, got parents %v, indices %v and resources %v", i, expect[i], batch.parents, batch.indices, resources)
+		}
+	}
+
+	// a bisected batch keeps the parents of the batch and adds the parents in the other half
+	parts := splitPreflightRequest(preflightBatch{request: request, indices: indices[1:], parents: indices[:1]}, results, 3)
+	if len(parts) != 2 || !reflect.DeepEqual(parts[1].parents, []int{0, 1}) || len(parts[1].request.Resources) != 4 {
+		t.Fatalf("expected the second part to hold the resource group and the virtual network, got %v", parts)
+	}
+}
+
+func Test_validateBatch_ParentErrors(t *testing.T) {
+	rgId := "/subscriptions/000/resourceGroups/rg"
+	saId := rgId + "/providers/Microsoft.Storage/storageAccounts/sa"
+	preflight = func(ctx context.Context, model PreflightRequestModel) (*PreflightResponseModel, error) {
+		body := `{"error":{"code":"InvalidTemplateDeployment","message":"validation failed","details":[{"code":"InvalidTagName","target":"` + rgId + `","message":"invalid tag"}]}}`
+		return nil, &azcore.ResponseError{
+			ErrorCode:  "InvalidTemplateDeployment",
+			StatusCode: http.StatusBadRequest,
+			RawResponse: &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			},
+		}
+	}
+	defer func() { preflight = Preflight }()
+
+	results := []PreflightResult{{ResourceId: rgId}, {ResourceId: saId}}
+	request := PreflightRequestModel{Resources: []map[string]interface{}{{"name": "rg"}, {"name": "sa"}}}
+	validateBatch(context.TODO(), preflightBatch{request: request, indices: []int{1}, parents: []int{0}}, results, &sync.Mutex{}, PreflightOptions{})
+
+	if len(results[0].Errors) != 0 || len(results[1].Errors) != 0 || results[1].Validated {
+		t.Fatalf("expected the error of the parent to be left to its own batch, got %v", results)
+	}
+}
+
+func Test_validateBatch_Bisect(t *testing.T) {
+	calls := 0
+	preflight = func(ctx context.Context, model PreflightRequestModel) (*PreflightResponseModel, error) {
+		calls++
+		for _, resource := range model.Resources {
+			if resource["name"] == "bad" {
+				return nil, &azcore.ResponseError{
+					ErrorCode:  "InvalidRequestContent",
+					StatusCode: http.StatusBadRequest,
+					RawResponse: &http.Response{
+						StatusCode: http.StatusBadRequest,
+						Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"InvalidRequestContent","message":"The request content was invalid."}}`)),
+					},
+				}
+			}
+		}
+		return &PreflightResponseModel{}, nil
+	}
+	defer func() { preflight = Preflight }()
+
+	names := []string{"sa0", "sa1", "bad", "sa3", "sa4"}
+	request := PreflightRequestModel{}
+	results := make([]PreflightResult, len(names))
+	indices := make([]int, len(names))
+	for i, name := range names {
+		request.Resources = append(request.Resources, map[string]interface{}{"name": name})
+		results[i].ResourceId = "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/" + name
+		indices[i] = i
+	}
+	validateBatch(context.TODO(), preflightBatch{request: request, indices: indices}, results, &sync.Mutex{}, PreflightOptions{})
+
+	for i, result := range results {
+		if names[i] == "bad" {
+			if len(result.Errors) != 1 || result.Errors[0].Code != "InvalidRequestContent" || result.Validated {
+				t.Fatalf("expected only %s to fail, got %v", names[i], result)
+			}
+			continue
+		}
+		if len(result.Errors) != 0 || !result.Validated {
+			t.Fatalf("expected %s to be validated, got %v", names[i], result)
+		}
+	}
+	if calls > 2*len(names) {
+		t.Fatalf("expected at most %d preflight requests, got %d", 2*len(names), calls)
+	}
+}
+
+func Test_validateBatch_Throttled(t *testing.T) {
+	calls := 0
+	preflight = func(ctx context.Context, model PreflightRequestModel) (*PreflightResponseModel, error) {
+		calls++
+		if calls == 1 {
+			return nil, &azcore.ResponseError{
+				ErrorCode:  "TooManyRequests",
+				StatusCode: http.StatusTooManyRequests,
+				RawResponse: &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header:     http.Header{"Retry-After": []string{"0"}},
+					Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"TooManyRequests","message":"throttled"}}`)),
+				},
+			}
+		}
+		return &PreflightResponseModel{}, nil
+	}
+	defer func() { preflight = Preflight }()
+
+	results := []PreflightResult{{ResourceId: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"}}
+	request := PreflightRequestModel{Resources: []map[string]interface{}{{"name": "sa"}}}
+	validateBatch(context.TODO(), preflightBatch{request: request, indices: []int{0}}, results, &sync.Mutex{}, PreflightOptions{MaxRetries: DefaultMaxRetries})
+
+	if !results[0].Validated || results[0].ThrottledRetries != 1 || results[0].RequestError != "" {
+		t.Fatalf("expected the resource to be validated after 1 retry, got %v", results[0])
+	}
+}
+
+func Test_throttlingDelay(t *testing.T) {
+	throttled := func(header http.Header) error {
+		return &azcore.ResponseError{
+			StatusCode:  http.StatusTooManyRequests,
+			RawResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header},
+		}
+	}
+	testcases := []struct {
+		err       error
+		retries   int
+		expected  time.Duration
+		throttled bool
+	}{
+		{err: throttled(http.Header{"Retry-After": []string{"7"}}), expected: 7 * time.Second, throttled: true},
+		{err: throttled(http.Header{}), retries: 2, expected: 4 * baseRetryDelay, throttled: true},
+		{err: &azcore.ResponseError{StatusCode: http.StatusBadRequest}},
+		{err: fmt.Errorf("connection reset")},
+	}
+
+	for _, tc := range testcases {
+		delay, ok := throttlingDelay(tc.err, tc.retries)
+		if delay != tc.expected || ok != tc.throttled {
+			t.Fatalf("expected %s, %v for %v, got %s, %v", tc.expected, tc.throttled, tc.err, delay, ok)
+		}
+	}
+}
@@ -15,6 +15,7 @@ var m string
 var (
 	mapping            map[string]map[string]string
 	pathPlaceholderMap map[string]interface{}
+	resourceGroupId    = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/myResourceGroup"
 )
 
 func init() {
@@ -187,4 +188,5 @@ func init() {
 			mapping[key][k] = strings.ReplaceAll(v, "/subscriptions/00000000-0000-0000-0000-000000000000", "/subscriptions/"+subscriptionId)
 		}
 	}
+	resourceGroupId = strings.ReplaceAll(resourceGroupId, "/subscriptions/00000000-0000-0000-0000-000000000000", "/subscriptions/"+subscriptionId)
 }
@@ -3,6 +3,8 @@ package placeholder
 import (
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/hashicorp/terraform-plugin-go/tftypes"
 )
@@ -49,8 +51,24 @@ func ForUnknownReference(references []string, valueType tftypes.Type) interface{
 	return out[0]
 }
 
+// ForPath returns the placeholder of the attribute path, like `azurerm_firewall.ip_configuration.0.subnet_id`.
+// The placeholders of the list elements are keyed by the path of the first element, so they're used for all the elements.
 func ForPath(path string) interface{} {
-	return pathPlaceholderMap[path]
+	if pathPlaceholder, ok := pathPlaceholderMap[path]; ok {
+		return pathPlaceholder
+	}
+	parts := strings.Split(path, ".")
+	for index, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[index] = "0"
+		}
+	}
+	return pathPlaceholderMap[strings.Join(parts, ".")]
+}
+
+// ForResourceGroup returns the placeholder ID of a resource group, used as the parent of resources whose parent is unknown.
+func ForResourceGroup() string {
+	return resourceGroupId
 }
 
 func ForResourceTypePath(resourceType string, path string) string {
@@ -41,3 +41,13 @@ func Test_ForPath_MapContains(t *testing.T) {
 	// Often populated via init(), so just ensure lookup doesn't panic and returns something for a known key if present
 	_ = ForPath("azurerm_spring_cloud_app.addon_json")
 }
+
+func Test_ForPath_ListElements(t *testing.T) {
+	first := ForPath("azurerm_firewall.ip_configuration.0.subnet_id")
+	if first == nil {
+		t.Fatalf("expected placeholder for the first ip configuration")
+	}
+	if second := ForPath("azurerm_firewall.ip_configuration.1.subnet_id"); second != first {
+		t.Fatalf("expected placeholder %v for the second ip configuration, got %v", first, second)
+	}
+}
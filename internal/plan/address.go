@@ -0,0 +1,45 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/aztfpreflight/internal/planfile"
+)
+
+// moduleCallInstance is a step of a module instance address, like `module.net["eu"]`.
+type moduleCallInstance struct {
+	Name string
+	// Key is the instance key in the address format, like `["eu"]` or `[0]`, it's empty if the module call has no count or for_each
+	Key string
+}
+
+func (m moduleCallInstance) String() string {
+	return fmt.Sprintf("module.%s%s", m.Name, m.Key)
+}
+
+// parseModuleAddress parses a module instance address, like `module.spoke["prod.eu"].module.net[0]`, into its module call instances.
+// Instance keys may contain dots, brackets and escaped quotes. An empty address is the root module.
+func parseModuleAddress(input string) ([]moduleCallInstance, error) {
+	steps, err := planfile.SplitAddress(input)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]moduleCallInstance, 0, len(steps)/2)
+	for i := 0; i < len(steps); i += 2 {
+		if steps[i].Name != "module" || steps[i].Index != nil || i+1 == len(steps) {
+			return nil, fmt.Errorf("invalid module address %q", input)
+		}
+		out = append(out, moduleCallInstance{Name: steps[i+1].Name, Key: planfile.FormatIndex(steps[i+1].Index)})
+	}
+	return out, nil
+}
+
+// formatModuleAddress formats the module call instances as a module instance address.
+func formatModuleAddress(instances []moduleCallInstance) string {
+	parts := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		parts = append(parts, instance.String())
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,79 @@
+package plan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseModuleAddress(t *testing.T) {
+	testcases := []struct {
+		Input     string
+		Expect    []moduleCallInstance
+		ExpectErr bool
+	}{
+		{
+			Input:  "",
+			Expect: []moduleCallInstance{},
+		},
+		{
+			Input:  "module.net",
+			Expect: []moduleCallInstance{{Name: "net"}},
+		},
+		{
+			Input:  `module.spoke["prod.eu"].module.net[0]`,
+			Expect: []moduleCallInstance{{Name: "spoke", Key: `["prod.eu"]`}, {Name: "net", Key: "[0]"}},
+		},
+		{
+			Input:  `module.spoke["a\"].module.b[0]"].module.net`,
+			Expect: []moduleCallInstance{{Name: "spoke", Key: `["a\"].module.b[0]"]`}, {Name: "net"}},
+		},
+		{
+			Input:  `module.data["module.x"]`,
+			Expect: []moduleCallInstance{{Name: "data", Key: `["module.x"]`}},
+		},
+		{
+			Input:     "module",
+			ExpectErr: true,
+		},
+		{
+			Input:     `module.net["eu"`,
+			ExpectErr: true,
+		},
+		{
+			Input:     "module.net[a]",
+			ExpectErr: true,
+		},
+		{
+			Input:     "azurerm_subnet.s",
+			ExpectErr: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Input, func(t *testing.T) {
+			actual, err := parseModuleAddress(testcase.Input)
+			if testcase.ExpectErr {
+				if err == nil {
+					t.Fatalf("Expected error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, actual)
+			}
+			if formatted := formatModuleAddress(actual); formatted != testcase.Input {
+				t.Fatalf("Expected %s, got %s", testcase.Input, formatted)
+			}
+		})
+	}
+}
+
+func Test_splitModuleAddress(t *testing.T) {
+	parent, name, key := splitModuleAddress(`module.spoke["prod.eu"].module.net[0]`)
+	if parent != `module.spoke["prod.eu"]` || name != "net" || key != "[0]" {
+		t.Fatalf("Expected module.spoke[\"prod.eu\"], net and [0], got %s, %s and %s", parent, name, key)
+	}
+}
@@ -0,0 +1,243 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/aztfpreflight/internal/placeholder"
+	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+const (
+	// azapiProviderType is the type name of the azapi provider, the last part of its source address.
+	azapiProviderType = "azapi"
+
+	AzapiResourceType       = "azapi_resource"
+	AzapiUpdateResourceType = "azapi_update_resource"
+
+	resourceManagerEndpoint = "https://management.azure.com"
+)
+
+// IsAzapiProvider reports whether the provider source address is the azapi provider, see IsAzurermProvider.
+func IsAzapiProvider(providerName string, sources []string) bool {
+	return matchProvider(providerName, sources, azapiProviderType)
+}
+
+// IsAzapiResourceType reports whether the azapi resource type is preflighted.
+// Other azapi resources, like azapi_resource_action, don't create or update resources with a PUT request.
+func IsAzapiResourceType(resourceType string) bool {
+	return resourceType == AzapiResourceType || resourceType == AzapiUpdateResourceType
+}
+
+// AzapiRequestModel builds the request model of an azapi resource from its planned value, the embedded provider isn't involved.
+// The ARM `type@apiVersion`, `parent_id` and `name` give the request URL, the `body`, `location`, `tags` and `identity` give the request body.
+// Unknown values are taken from the known values in the configuration, or filled with placeholders.
+// The request of an azapi_update_resource is partial, it only holds the properties to update and it's not sent to the preflight API.
+func AzapiRequestModel(request ApplyRequest) (types.RequestModel, error) {
+	after, ok := request.AfterV.(map[string]interface{})
+	if !ok {
+		return types.RequestModel{}, fmt.Errorf("the planned value of %s is not an object", request.Address)
+	}
+	afterUnknown, _ := request.AfterUnknown.(map[string]interface{})
+	var config map[string]*tfjson.Expression
+	if request.Config != nil && len(request.Config.NestedBlocks) > 0 {
+		config = request.Config.NestedBlocks[0]
+	}
+	value := func(key string) interface{} {
+		if v, ok := after[key]; ok && v != nil {
+			return v
+		}
+		if expr, ok := config[key]; ok && expr != nil && expr.ConstantValue != nil && expr.ConstantValue != tfjson.UnknownConstantValue {
+			return expr.ConstantValue
+		}
+		return nil
+	}
+	references := func(key string) []string {
+		if expr, ok := config[key]; ok && expr != nil {
+			return expr.References
+		}
+		return nil
+	}
+	warnings := make([]string, 0)
+
+	typeValue, _ := value("type").(string)
+	resourceType, apiVersion, found := strings.Cut(typeValue, "@")
+	if !found || resourceType == "" || apiVersion == "" {
+		return types.RequestModel{}, fmt.Errorf("the resource type of %s is unknown or doesn't have an API version: %q", request.Address, typeValue)
+	}
+
+	resourceId, _ := value("resource_id").(string)
+	if resourceId == "" && request.ResourceType == AzapiUpdateResourceType {
+		if v, ok := placeholder.ForUnknownReference(references("resource_id"), nil).(string); ok {
+			resourceId = v
+		}
+	}
+	if resourceId == "" {
+		parentId, _ := value("parent_id").(string)
+		if parentId == "" {
+			if v, ok := placeholder.ForUnknownReference(references("parent_id"), nil).(string); ok {
+				parentId = v
+			} else {
+				parentId = placeholder.ForResourceGroup()
+			}
+		}
+		name, _ := value("name").(string)
+		if name == "" {
+			name = fmt.Sprintf("%s.name-unknown", request.ResourceType)
+		}
+		resourceId = AzapiResourceId(resourceType, parentId, name)
+	}
+
+	body := make(map[string]interface{})
+	switch v := value("body").(type) {
+	case map[string]interface{}:
+		body = v
+	case string:
+		// azapi v1 uses a JSON string as the body
+		if err := json.Unmarshal([]byte(v), &body); err != nil {
+			return types.RequestModel{}, fmt.Errorf("parsing the body of %s: %w", request.Address, err)
+		}
+	}
+	switch v := afterUnknown["body"].(type) {
+	case bool:
+		if v {
+			warnings = append(warnings, "the body is unknown until apply, an empty body is used")
+		}
+	default:
+		if filled, ok := fillUnknowns(body, v, references("body"), request.ResourceType+".body").(map[string]interface{}); ok {
+			body = filled
+		}
+	}
+
+	if request.ResourceType == AzapiResourceType {
+		if location, ok := value("location").(string); ok && location != "" {
+			body["location"] = location
+		} else if afterUnknown["location"] == true {
+			warnings = append(warnings, "the location is unknown until apply, the request is sent without a location")
+		}
+		if tags, ok := value("tags").(map[string]interface{}); ok && len(tags) > 0 {
+			body["tags"] = tags
+		}
+		if identity := azapiIdentity(after["identity"], afterUnknown["identity"], config["identity"]); identity != nil {
+			body["identity"] = identity
+		}
+	}
+
+	bodyJson, err := json.Marshal(body)
+	if err != nil {
+		return types.RequestModel{}, fmt.Errorf("marshaling the body of %s: %w", request.Address, err)
+	}
+	out := types.NewRequestModel(http.MethodPut, fmt.Sprintf("%s%s?api-version=%s", resourceManagerEndpoint, resourceId, apiVersion), string(bodyJson), nil)
+	// the provider merges the body of azapi_update_resource into the existing resource, which isn't known before apply
+	out.Partial = request.ResourceType == AzapiUpdateResourceType
+	if len(warnings) > 0 {
+		out.Warnings = warnings
+	}
+	return out, nil
+}
+
+// AzapiResourceId builds the resource ID of an azapi resource the same way as the azapi provider does.
+// Child resource types are appended to the parent ID, other resource types are added as a provider resource of the parent scope.
+func AzapiResourceId(resourceType string, parentId string, name string) string {
+	parentId = strings.TrimSuffix(parentId, "/")
+	parts := strings.Split(resourceType, "/")
+	switch {
+	case strings.EqualFold(resourceType, arm.ResourceGroupResourceType.String()):
+		return fmt.Sprintf("%s/resourceGroups/%s", parentId, name)
+	case len(parts) > 2:
+		return fmt.Sprintf("%s/%s/%s", parentId, parts[len(parts)-1], name)
+	}
+	return fmt.Sprintf("%s/providers/%s/%s", parentId, resourceType, name)
+}
+
+// azapiIdentity converts the identity block of an azapi resource to the ARM identity.
+func azapiIdentity(input interface{}, unknown interface{}, config *tfjson.Expression) interface{} {
+	blocks, ok := input.([]interface{})
+	if !ok || len(blocks) == 0 {
+		return nil
+	}
+	block, ok := blocks[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	identityType, _ := block["type"].(string)
+	if identityType == "" {
+		return nil
+	}
+	out := map[string]interface{}{
+		"type": identityType,
+	}
+
+	ids := make([]string, 0)
+	if v, ok := block["identity_ids"].([]interface{}); ok {
+		for _, id := range v {
+			if s, ok := id.(string); ok {
+				ids = append(ids, s)
+			}
+		}
+	}
+	if unknownBlocks, ok := unknown.([]interface{}); ok && len(unknownBlocks) > 0 {
+		if unknownBlock, ok := unknownBlocks[0].(map[string]interface{}); ok && unknownBlock["identity_ids"] != nil && unknownBlock["identity_ids"] != false {
+			var references []string
+			if config != nil && len(config.NestedBlocks) > 0 && config.NestedBlocks[0]["identity_ids"] != nil {
+				references = config.NestedBlocks[0]["identity_ids"].References
+			}
+			if v, ok := placeholder.ForUnknownReference(references, nil).(string); ok {
+				ids = append(ids, v)
+			}
+		}
+	}
+	if len(ids) > 0 {
+		userAssignedIdentities := make(map[string]interface{})
+		for _, id := range ids {
+			userAssignedIdentities[id] = map[string]interface{}{}
+		}
+		out["userAssignedIdentities"] = userAssignedIdentities
+	}
+	return out
+}
+
+// fillUnknowns fills the unknown values of the body, which are marked by the `after_unknown` structure, with placeholders.
+func fillUnknowns(input interface{}, unknown interface{}, references []string, path string) interface{} {
+	switch v := unknown.(type) {
+	case bool:
+		if !v {
+			return input
+		}
+		if pathPlaceholder := placeholder.ForPath(path); pathPlaceholder != nil {
+			return pathPlaceholder
+		}
+		// the references of the body can't be matched to the unknown values, they are only used for ID-like properties
+		if key := path[strings.LastIndex(path, ".")+1:]; strings.HasSuffix(strings.ToLower(key), "id") {
+			if refPlaceholder, ok := placeholder.ForUnknownReference(references, nil).(string); ok {
+				return refPlaceholder
+			}
+		}
+		return fmt.Sprintf("%s-%s", path, "unknown")
+	case map[string]interface{}:
+		object, ok := input.(map[string]interface{})
+		if !ok {
+			object = make(map[string]interface{})
+		}
+		for key, value := range v {
+			object[key] = fillUnknowns(object[key], value, references, fmt.Sprintf("%s.%s", path, key))
+		}
+		return object
+	case []interface{}:
+		list, ok := input.([]interface{})
+		if !ok {
+			return input
+		}
+		for index := range list {
+			if index < len(v) {
+				list[index] = fillUnknowns(list[index], v[index], references, fmt.Sprintf("%s.%d", path, index))
+			}
+		}
+		return list
+	}
+	return input
+}
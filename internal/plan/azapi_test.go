@@ -0,0 +1,226 @@
+package plan_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/placeholder"
+	"github.com/Azure/aztfpreflight/internal/plan"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_AzapiResourceId(t *testing.T) {
+	testcases := []struct {
+		ResourceType string
+		ParentId     string
+		Name         string
+		Expect       string
+	}{
+		{
+			ResourceType: "Microsoft.Network/virtualNetworks",
+			ParentId:     "/subscriptions/000/resourceGroups/rg",
+			Name:         "vnet",
+			Expect:       "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+		},
+		{
+			ResourceType: "Microsoft.Network/virtualNetworks/subnets",
+			ParentId:     "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			Name:         "subnet",
+			Expect:       "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet",
+		},
+		{
+			ResourceType: "Microsoft.Resources/resourceGroups",
+			ParentId:     "/subscriptions/000",
+			Name:         "rg",
+			Expect:       "/subscriptions/000/resourceGroups/rg",
+		},
+		{
+			ResourceType: "Microsoft.Authorization/roleAssignments",
+			ParentId:     "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa",
+			Name:         "ra",
+			Expect:       "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa/providers/Microsoft.Authorization/roleAssignments/ra",
+		},
+	}
+
+	for _, testcase := range testcases {
+		if actual := plan.AzapiResourceId(testcase.ResourceType, testcase.ParentId, testcase.Name); actual != testcase.Expect {
+			t.Fatalf("Expected %s for type %s, got %s", testcase.Expect, testcase.ResourceType, actual)
+		}
+	}
+}
+
+func Test_AzapiRequestModel(t *testing.T) {
+	testcases := []struct {
+		Name         string
+		Request      plan.ApplyRequest
+		ExpectURL    string
+		ExpectBody   map[string]interface{}
+		ExpectErr    bool
+		ExpectWarned bool
+	}{
+		{
+			Name: "resource with dynamic body",
+			Request: plan.ApplyRequest{
+				ResourceType: plan.AzapiResourceType,
+				Address:      "azapi_resource.test",
+				AfterV: map[string]interface{}{
+					"type":      "Microsoft.Network/virtualNetworks@2023-04-01",
+					"name":      "vnet",
+					"parent_id": "/subscriptions/000/resourceGroups/rg",
+					"location":  "westeurope",
+					"tags":      map[string]interface{}{"env": "test"},
+					"body": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"addressSpace": map[string]interface{}{"addressPrefixes": []interface{}{"10.0.0.0/16"}},
+						},
+					},
+				},
+				AfterUnknown: map[string]interface{}{
+					"id": true,
+					"body": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"ddosProtectionPlan": map[string]interface{}{"id": true},
+						},
+					},
+				},
+				Config: azapiConfig(map[string]*tfjson.Expression{
+					"body": {ExpressionData: &tfjson.ExpressionData{
+						ConstantValue: tfjson.UnknownConstantValue,
+						References:    []string{"azurerm_network_ddos_protection_plan.test.id", "azurerm_network_ddos_protection_plan.test"},
+					}},
+				}),
+			},
+			ExpectURL: "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			ExpectBody: map[string]interface{}{
+				"location": "westeurope",
+				"tags":     map[string]interface{}{"env": "test"},
+				"properties": map[string]interface{}{
+					"addressSpace":       map[string]interface{}{"addressPrefixes": []interface{}{"10.0.0.0/16"}},
+					"ddosProtectionPlan": map[string]interface{}{"id": placeholder.ForResourceTypePath("azurerm_network_ddos_protection_plan", "id")},
+				},
+			},
+		},
+		{
+			Name: "resource with JSON string body, unknown parent and identity",
+			Request: plan.ApplyRequest{
+				ResourceType: plan.AzapiResourceType,
+				Address:      "azapi_resource.test",
+				AfterV: map[string]interface{}{
+					"type":     "Microsoft.Automation/automationAccounts@2023-11-01",
+					"name":     "aa",
+					"location": "westeurope",
+					"body":     `{"properties":{"sku":{"name":"Basic"}}}`,
+					"identity": []interface{}{map[string]interface{}{"type": "SystemAssigned"}},
+				},
+				AfterUnknown: map[string]interface{}{
+					"parent_id": true,
+				},
+				Config: azapiConfig(map[string]*tfjson.Expression{
+					"parent_id": {ExpressionData: &tfjson.ExpressionData{
+						ConstantValue: tfjson.UnknownConstantValue,
+						References:    []string{"azapi_resource.rg.id", "azapi_resource.rg"},
+					}},
+				}),
+			},
+			ExpectURL: "https://management.azure.com" + placeholder.ForResourceGroup() + "/providers/Microsoft.Automation/automationAccounts/aa?api-version=2023-11-01",
+			ExpectBody: map[string]interface{}{
+				"location":   "westeurope",
+				"identity":   map[string]interface{}{"type": "SystemAssigned"},
+				"properties": map[string]interface{}{"sku": map[string]interface{}{"name": "Basic"}},
+			},
+		},
+		{
+			Name: "parent resolved from a known value in the configuration",
+			Request: plan.ApplyRequest{
+				ResourceType: plan.AzapiResourceType,
+				Address:      "azapi_resource.subnet",
+				AfterV: map[string]interface{}{
+					"type": "Microsoft.Network/virtualNetworks/subnets@2023-04-01",
+					"name": "subnet",
+					"body": map[string]interface{}{},
+				},
+				AfterUnknown: map[string]interface{}{
+					"parent_id": true,
+				},
+				Config: azapiConfig(map[string]*tfjson.Expression{
+					"parent_id": {ExpressionData: &tfjson.ExpressionData{
+						ConstantValue: "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+					}},
+				}),
+			},
+			ExpectURL:  "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet?api-version=2023-04-01",
+			ExpectBody: map[string]interface{}{},
+		},
+		{
+			Name: "update resource with unknown body",
+			Request: plan.ApplyRequest{
+				ResourceType: plan.AzapiUpdateResourceType,
+				Address:      "azapi_update_resource.test",
+				AfterV: map[string]interface{}{
+					"type":        "Microsoft.Network/virtualNetworks@2023-04-01",
+					"resource_id": "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+				},
+				AfterUnknown: map[string]interface{}{
+					"body": true,
+				},
+			},
+			ExpectURL:    "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			ExpectBody:   map[string]interface{}{},
+			ExpectWarned: true,
+		},
+		{
+			Name: "unknown type",
+			Request: plan.ApplyRequest{
+				ResourceType: plan.AzapiResourceType,
+				Address:      "azapi_resource.test",
+				AfterV: map[string]interface{}{
+					"name": "test",
+				},
+				AfterUnknown: map[string]interface{}{
+					"type": true,
+				},
+			},
+			ExpectErr: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			actual, err := plan.AzapiRequestModel(testcase.Request)
+			if testcase.ExpectErr {
+				if err == nil {
+					t.Fatalf("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual.URL != testcase.ExpectURL {
+				t.Fatalf("Expected URL %s, got %s", testcase.ExpectURL, actual.URL)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal([]byte(actual.Body), &body); err != nil {
+				t.Fatalf("Failed to parse body: %v", err)
+			}
+			if !reflect.DeepEqual(body, testcase.ExpectBody) {
+				t.Fatalf("Expected body %v, got %v", testcase.ExpectBody, body)
+			}
+			if warned := len(actual.Warnings) > 0; warned != testcase.ExpectWarned {
+				t.Fatalf("Expected warnings %v, got %v", testcase.ExpectWarned, actual.Warnings)
+			}
+			if partial := testcase.Request.ResourceType == plan.AzapiUpdateResourceType; actual.Partial != partial || (actual.PreflightSkipReason() != "") != partial {
+				t.Fatalf("Expected a partial request: %v, got %v with skip reason %q", partial, actual.Partial, actual.PreflightSkipReason())
+			}
+		})
+	}
+}
+
+func azapiConfig(expressions map[string]*tfjson.Expression) *tfjson.Expression {
+	return &tfjson.Expression{
+		ExpressionData: &tfjson.ExpressionData{
+			NestedBlocks: []map[string]*tfjson.Expression{expressions},
+		},
+	}
+}
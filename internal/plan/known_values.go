@@ -0,0 +1,65 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// KnownValues returns the values of a planned resource which are known after its payload is generated, they're used to resolve the references of its dependents.
+// The keys are the references resolved by the ReferenceResolver, like `azurerm_subnet.test["a"].id` or `module.lb.azurerm_lb.test.frontend_ip_configuration[0].name`.
+// The strings, numbers and bools are taken from the planned value, the `id`, `name` and `resource_group_name` are taken from the ARM resource ID of the request URL,
+// and the `location` from the request body, if they're not in the planned value.
+func KnownValues(address string, plannedValue interface{}, model types.RequestModel) map[string]interface{} {
+	out := make(map[string]interface{})
+	flattenKnownValues(out, address, plannedValue)
+
+	parsedUrl, err := url.Parse(model.URL)
+	if err == nil {
+		if armId, err := arm.ParseResourceID(parsedUrl.Path); err == nil {
+			setIfMissing(out, address+".name", armId.Name)
+			setIfMissing(out, address+".resource_group_name", armId.ResourceGroupName)
+		}
+	}
+	if armResourceId := referenceId(model.URL); armResourceId != "" {
+		out[address+".id"] = armResourceId
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(model.Body), &body); err == nil {
+		if location, ok := body["location"].(string); ok {
+			setIfMissing(out, address+".location", location)
+		}
+	}
+	return out
+}
+
+// flattenKnownValues adds the string, number and bool values in the input to the output, the placeholders of the unknown values are skipped.
+func flattenKnownValues(out map[string]interface{}, path string, input interface{}) {
+	switch v := input.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			flattenKnownValues(out, fmt.Sprintf("%s.%s", path, key), value)
+		}
+	case []interface{}:
+		for index, value := range v {
+			flattenKnownValues(out, fmt.Sprintf("%s[%d]", path, index), value)
+		}
+	case string:
+		if v != "" && !strings.HasSuffix(v, "-unknown") {
+			out[path] = v
+		}
+	case float64, json.Number, bool:
+		out[path] = v
+	}
+}
+
+func setIfMissing(out map[string]interface{}, key string, value string) {
+	if _, ok := out[key]; !ok && value != "" {
+		out[key] = value
+	}
+}
@@ -0,0 +1,41 @@
+package plan_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/plan"
+	"github.com/Azure/aztfpreflight/internal/types"
+)
+
+func Test_KnownValues(t *testing.T) {
+	subnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/a"
+	plannedValue := map[string]interface{}{
+		"name":             "a",
+		"address_prefixes": []interface{}{"10.0.0.0/24"},
+		"delegation": []interface{}{
+			map[string]interface{}{"name": "delegation", "actions": nil},
+		},
+		"default_outbound_access_enabled": true,
+		"priority":                        float64(100),
+		"service_endpoint_policy_ids":     "azurerm_subnet.service_endpoint_policy_ids-unknown",
+	}
+	model := types.RequestModel{
+		URL:  "https://management.azure.com" + subnetId + "?api-version=2023-09-01",
+		Body: `{"location":"westeurope","properties":{}}`,
+	}
+
+	expect := map[string]interface{}{
+		`azurerm_subnet.s["a"].id`:                              subnetId,
+		`azurerm_subnet.s["a"].name`:                            "a",
+		`azurerm_subnet.s["a"].resource_group_name`:             "rg",
+		`azurerm_subnet.s["a"].location`:                        "westeurope",
+		`azurerm_subnet.s["a"].address_prefixes[0]`:             "10.0.0.0/24",
+		`azurerm_subnet.s["a"].delegation[0].name`:              "delegation",
+		`azurerm_subnet.s["a"].priority`:                        float64(100),
+		`azurerm_subnet.s["a"].default_outbound_access_enabled`: true,
+	}
+	if actual := plan.KnownValues(`azurerm_subnet.s["a"]`, plannedValue, model); !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+}
@@ -3,7 +3,10 @@ package plan
 import (
 	"fmt"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/Azure/aztfpreflight/internal/placeholder"
 	"github.com/Azure/aztfpreflight/internal/tfclient"
@@ -14,100 +17,328 @@ import (
 )
 
 type ApplyRequest struct {
-	AfterV       interface{}
-	Config       *tfjson.Expression
-	ResourceType string
-	Address      string
-	DependsOn    []string
+	AfterV        interface{}
+	AfterUnknown  interface{}
+	BeforeV       interface{}
+	Config        *tfjson.Expression
+	ResourceType  string
+	Address       string
+	ModuleAddress string
+	DependsOn     []string
+	Action        types.Action
+	// CreateBeforeDestroy is true when a replacement creates the new resource before the old one is destroyed
+	CreateBeforeDestroy bool
+	// ProviderConfig is the configuration of the azurerm provider used by the resource, see ProviderConfiguration
+	ProviderConfig map[string]interface{}
 }
 
-func ExportAzurePayload(tfplan *tfjson.Plan) []types.RequestModel {
+// azurermProviderType is the type name of the azurerm provider, the last part of its source address.
+const azurermProviderType = "azurerm"
+
+// Options configures which resources of the plan are exported.
+type Options struct {
+	// ProviderSources is the allow-list of provider source addresses, like `registry.terraform.io/hashicorp/azurerm`.
+	// When it's empty, the resources of any provider whose type name is azurerm or azapi are exported,
+	// e.g. from the OpenTofu registry, a private registry mirror or a provider source override.
+	// Otherwise only the azurerm and azapi providers in the list are exported, the azapi provider must be listed too.
+	ProviderSources []string
+	// Concurrency is the max number of resources whose payloads are generated concurrently, DefaultConcurrency is used when it's not positive.
+	// Only the resources in the same dependency level are generated concurrently, see TopoSortLevels.
+	Concurrency int
+}
+
+// DefaultConcurrency is the default max number of concurrent payload generation workers.
+const DefaultConcurrency = 4
+
+func ExportAzurePayload(tfplan *tfjson.Plan, options Options) []types.RequestModel {
 	out := make([]types.RequestModel, 0)
-	client := tfclient.NewTerraformClient()
 
+	// the configuration is optional in the plan JSON, the references are not resolved without it
+	var rootModule *tfjson.ConfigModule
+	if tfplan.Config != nil {
+		rootModule = tfplan.Config.RootModule
+	}
+	resolver := NewReferenceResolver(rootModule)
 	requests := make([]ApplyRequest, 0)
 	for _, change := range tfplan.ResourceChanges {
-		// Skip resources that are not from the azurerm provider
-		if change.ProviderName != "registry.terraform.io/hashicorp/azurerm" {
+		// Skip resources that are not from the azurerm provider, or the azapi resources that are not preflighted
+		isAzapi := IsAzapiResourceType(change.Type) && IsAzapiProvider(change.ProviderName, options.ProviderSources)
+		isAzurerm := !strings.HasPrefix(change.Type, azapiProviderType+"_") && IsAzurermProvider(change.ProviderName, options.ProviderSources)
+		if !isAzapi && !isAzurerm {
 			continue
 		}
 
-		// Skip resources that are not being created or updated
-		if !change.Change.Actions.Create() && !change.Change.Actions.Update() {
+		// Skip resources that are not being created, updated or replaced
+		action := ChangeAction(change.Change.Actions)
+		if action == "" {
 			continue
 		}
 
-		address := fmt.Sprintf("%s.%s", change.Type, change.Name)
-		if change.ModuleAddress != "" {
-			address = fmt.Sprintf("%s.%s.%s", change.ModuleAddress, change.Type, change.Name)
+		// the references in the configuration are resolved across the modules, so they match the resource addresses in the plan
+		config := resolver.ResourceConfig(change.ModuleAddress, change.Mode, change.Type, change.Name, change.Index)
+		var providerConfig map[string]interface{}
+		if isAzurerm {
+			providerConfig = ProviderConfiguration(tfplan, resolver.ProviderConfigKey(change.ModuleAddress, change.Mode, change.Type, change.Name))
 		}
-		config := FindConfigModule(tfplan.Config.RootModule, address)
 
 		requests = append(requests, ApplyRequest{
-			AfterV:       change.Change.After,
-			Config:       config,
-			ResourceType: change.Type,
-			Address:      change.Address,
-			DependsOn:    listDependsOn(config),
+			AfterV:              change.Change.After,
+			AfterUnknown:        change.Change.AfterUnknown,
+			BeforeV:             change.Change.Before,
+			Config:              config,
+			ResourceType:        change.Type,
+			Address:             change.Address,
+			ModuleAddress:       change.ModuleAddress,
+			DependsOn:           listDependsOn(config),
+			Action:              action,
+			CreateBeforeDestroy: change.Change.Actions.CreateBeforeDestroy(),
+			ProviderConfig:      providerConfig,
 		})
 	}
 
-	requests = TopoSortRequests(requests)
+	levels := TopoSortLevels(requests)
 
-	for i, request := range requests {
-		valueType := client.ValueType(request.ResourceType)
-		plannedValue := PlannedValue(request.AfterV, request.Config, valueType, request.ResourceType)
+	// the requests in a dependency cycle are still generated, their references in the cycle are resolved with placeholders
+	cycleWarnings := make(map[string]string)
+	for _, cycle := range FindCycles(requests) {
+		warning := fmt.Sprintf("dependency cycle between %s, the references in the cycle are resolved with placeholders", strings.Join(cycle, ", "))
+		if len(cycle) == 1 {
+			warning = "the resource references itself, the reference is resolved with a placeholder"
+		}
+		for _, address := range cycle {
+			cycleWarnings[address] = warning
+		}
+	}
 
-		err := client.ApplyResource(request.ResourceType, plannedValue)
-		errMsg := ""
-		if err != nil {
-			errMsg = err.Error()
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	maxWidth := 0
+	for _, level := range levels {
+		maxWidth = max(maxWidth, len(level))
+	}
+	concurrency = max(min(concurrency, maxWidth), 1)
+
+	// each worker has its own embedded provider, so the concurrent applies don't share the interceptor cache,
+	// and each provider configuration has its own providers, so the resources are applied with the configuration they use in the plan.
+	// A configuration has as many providers as its requests in a dependency level, up to the concurrency.
+	providerConfigs := make(map[string]map[string]interface{})
+	poolSizes := make(map[string]int)
+	for _, level := range levels {
+		widths := make(map[string]int)
+		for _, request := range level {
+			key := providerConfigurationKey(request.ProviderConfig)
+			providerConfigs[key] = request.ProviderConfig
+			widths[key]++
+		}
+		for key, width := range widths {
+			poolSizes[key] = max(poolSizes[key], min(width, concurrency))
 		}
+	}
+	pools := make(map[string]chan *tfclient.TerraformClient)
+	var schemaClient *tfclient.TerraformClient
+	for key, size := range poolSizes {
+		pools[key] = make(chan *tfclient.TerraformClient, size)
+		for i := 0; i < size; i++ {
+			schemaClient = tfclient.NewTerraformClientWithConfig(providerConfigs[key])
+			pools[key] <- schemaClient
+		}
+	}
+	slots := make(chan struct{}, concurrency)
+	restoreLogs := tfclient.DisableProviderLogs()
+	defer restoreLogs()
+
+	// the references to the existing resources and data sources are resolved with their real values before any placeholder is used
+	knownValues := PriorStateValues(tfplan)
+	// the GET requests of the existing resources, of the resources outside the plan and of the resources created in the previous levels
+	// are answered with synthetic resources
+	tfclient.ResetSession()
+	for resourceId, body := range PlaceholderSessionResources(tfplan) {
+		tfclient.SetSessionResource(resourceId, body)
+	}
+	for resourceId, body := range PriorStateSessionResources(tfplan) {
+		tfclient.SetSessionResource(resourceId, body)
+	}
 
-		models := types.NewRequestModelsFromError(errMsg)
-		if len(models) == 0 {
-			model := types.RequestModel{
-				Address: request.Address,
-				Failed: &types.FailedCase{
-					Detail: errMsg,
-				},
+	for _, level := range levels {
+		// the resource schemas are the same for all the provider configurations
+		for j := range level {
+			level[j].Config = UpdateConfigWithKnownValues(level[j].Config, knownValues, schemaClient.ValueType(level[j].ResourceType))
+		}
+
+		results := make([][]types.RequestModel, len(level))
+		plannedValues := make([]interface{}, len(level))
+		var wg sync.WaitGroup
+		for j := range level {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(j int) {
+				pool := pools[providerConfigurationKey(level[j].ProviderConfig)]
+				client := <-pool
+				defer func() {
+					pool <- client
+					<-slots
+					wg.Done()
+				}()
+				results[j], plannedValues[j] = generateRequestModels(client, level[j])
+			}(j)
+		}
+		wg.Wait()
+
+		for j, request := range level {
+			if warning, ok := cycleWarnings[request.Address]; ok {
+				results[j][0].Warnings = append(results[j][0].Warnings, warning)
 			}
-			out = append(out, model)
-			continue
-		} else {
-			for index := range models {
-				models[index].Address = request.Address
+			out = append(out, results[j]...)
+			if results[j][0].Failed != nil {
+				continue
+			}
+			for _, model := range results[j] {
+				if body, ok := SessionResource(model); ok {
+					tfclient.SetSessionResource(model.ResourceId, body)
+				}
+			}
+			for key, value := range KnownValues(request.Address, plannedValues[j], results[j][0]) {
+				knownValues[key] = value
 			}
-			out = append(out, models...)
 		}
+	}
+
+	return out
+}
 
-		refValue := make(map[string]string)
-		model := models[0]
-		parsedUrl, err := url.Parse(model.URL)
+// generateRequestModels generates the request models of the resource, a single failed model is returned if it can't be generated.
+// The planned value with the unknown values filled is returned too, it holds the known values of the resource for its dependents.
+func generateRequestModels(client *tfclient.TerraformClient, request ApplyRequest) ([]types.RequestModel, interface{}) {
+	var models []types.RequestModel
+	errMsg := ""
+	plannedValue := request.AfterV
+	if IsAzapiResourceType(request.ResourceType) {
+		// azapi resources already hold the ARM request, they don't need the embedded provider
+		model, err := AzapiRequestModel(request)
 		if err != nil {
-			continue
+			errMsg = err.Error()
+		} else {
+			models = []types.RequestModel{model}
 		}
+	} else {
+		valueType := client.ValueType(request.ResourceType)
+		plannedValue = PlannedValue(request.AfterV, request.AfterUnknown, request.Config, valueType, request.ResourceType)
 
-		resourceId := parsedUrl.Path
-		armId, err := arm.ParseResourceID(resourceId)
+		// updates are applied on top of the prior state, so they send the same requests as a real apply
+		var prior interface{}
+		if request.Action == types.ActionUpdate {
+			prior = request.BeforeV
+		}
+		applied, err := client.ApplyResource(request.ResourceType, prior, plannedValue)
 		if err != nil {
-			continue
+			errMsg = err.Error()
+		}
+		models = applied
+	}
+
+	if len(models) == 0 {
+		return []types.RequestModel{
+			{
+				Address:       request.Address,
+				ModuleAddress: request.ModuleAddress,
+				Action:        request.Action,
+				Failed: &types.FailedCase{
+					Detail: errMsg,
+				},
+			},
+		}, plannedValue
+	}
+	for index := range models {
+		models[index].Address = request.Address
+		models[index].ModuleAddress = request.ModuleAddress
+		models[index].Action = request.Action
+	}
+	types.SetRequestRoles(models)
+	for index := range models {
+		if reason := models[index].PreflightSkipReason(); reason != "" && models[index].Role != types.RoleAction {
+			models[index].Warnings = append(models[index].Warnings, fmt.Sprintf("the %s request %d is not validated by the preflight API, %s", models[index].Method, models[index].Sequence, reason))
 		}
+	}
+	if request.CreateBeforeDestroy && HasReplaceConflict(request.BeforeV, models[0].URL) {
+		models[0].Warnings = append(models[0].Warnings, "create-before-destroy replacement uses the same resource ID as the existing resource, the new resource will clash with the one still in place")
+	}
+	return models, plannedValue
+}
+
+// referenceId returns the ARM resource ID of the request URL, which is used as the `id` of the resource by its dependents.
+func referenceId(requestUrl string) string {
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return ""
+	}
+	armId, err := arm.ParseResourceID(parsedUrl.Path)
+	if err != nil {
+		return ""
+	}
+	// fix resource ID format for Spring Cloud
+	return strings.ReplaceAll(armId.String(), "/Microsoft.AppPlatform/Spring", "/Microsoft.AppPlatform/spring")
+}
 
-		armResourceId := armId.String()
-		// fix resource ID format for Spring Cloud
-		armResourceId = strings.ReplaceAll(armResourceId, "/Microsoft.AppPlatform/Spring", "/Microsoft.AppPlatform/spring")
-		refValue[fmt.Sprintf("%s.id", request.Address)] = armResourceId
+// IsAzurermProvider reports whether the provider source address is the azurerm provider.
+// The provider type name, the last part of the source address, must be azurerm, and the source address must be in the allow-list if it's not empty.
+func IsAzurermProvider(providerName string, sources []string) bool {
+	return matchProvider(providerName, sources, azurermProviderType)
+}
 
-		for j := i + 1; j < len(requests); j++ {
-			requests[j].Config = UpdateConfigWithKnownValues(requests[j].Config, refValue, client.ValueType(requests[j].ResourceType))
+func matchProvider(providerName string, sources []string, providerType string) bool {
+	parts := strings.Split(providerName, "/")
+	if !strings.EqualFold(parts[len(parts)-1], providerType) {
+		return false
+	}
+	if len(sources) == 0 {
+		return true
+	}
+	for _, source := range sources {
+		if strings.EqualFold(providerName, source) {
+			return true
 		}
 	}
+	return false
+}
 
-	return out
+// ChangeAction returns the action to preflight for the planned actions, or an empty string if the change should be skipped.
+// Replacements are preflighted like creates, because the new resource is sent to Azure as a new PUT request.
+func ChangeAction(actions tfjson.Actions) types.Action {
+	switch {
+	case actions.Create():
+		return types.ActionCreate
+	case actions.Update():
+		return types.ActionUpdate
+	case actions.Replace():
+		return types.ActionReplace
+	}
+	return ""
+}
+
+// HasReplaceConflict reports whether the new resource of a replacement will be created with the same ARM resource ID as the existing one.
+func HasReplaceConflict(before interface{}, requestUrl string) bool {
+	beforeMap, ok := before.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	beforeId, ok := beforeMap["id"].(string)
+	if !ok || beforeId == "" {
+		return false
+	}
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSuffix(beforeId, "/"), strings.TrimSuffix(parsedUrl.Path, "/"))
 }
 
-func UpdateConfigWithKnownValues(config *tfjson.Expression, refValue map[string]string, valueType tftypes.Type) *tfjson.Expression {
+// UpdateConfigWithKnownValues replaces the expressions which are a single reference to a known value with the value.
+// Only the expressions resolved by the ReferenceResolver from a binary plan file are known to be a single reference,
+// the other expressions, like `"${azurerm_resource_group.rg.name}-vnet"` or all the expressions of the JSON plans, have a nil constant value.
+// They're left to the planned value, their unknown values are filled with placeholders.
+func UpdateConfigWithKnownValues(config *tfjson.Expression, refValue map[string]interface{}, valueType tftypes.Type) *tfjson.Expression {
 	if config == nil {
 		return nil
 	}
@@ -115,24 +346,28 @@ func UpdateConfigWithKnownValues(config *tfjson.Expression, refValue map[string]
 		return config
 	}
 	if len(config.References) > 0 {
-		for _, ref := range config.References {
-			if val, ok := refValue[ref]; ok {
-				isValList := false
-				if valueType != nil {
-					switch valueType.(type) {
-					case tftypes.List, tftypes.Tuple, tftypes.Set:
-						isValList = true
-					default:
-						isValList = false
-					}
-				}
-				config.ConstantValue = val
-				if isValList {
-					config.ConstantValue = []string{val}
+		if config.ConstantValue != tfjson.UnknownConstantValue {
+			return config
+		}
+		ref, ok := singleReference(config.References)
+		if !ok {
+			return config
+		}
+		if val, ok := refValue[ref]; ok {
+			isValList := false
+			if valueType != nil {
+				switch valueType.(type) {
+				case tftypes.List, tftypes.Tuple, tftypes.Set:
+					isValList = true
+				default:
+					isValList = false
 				}
-				config.References = nil
-				break
 			}
+			config.ConstantValue = val
+			if isValList {
+				config.ConstantValue = []interface{}{val}
+			}
+			config.References = nil
 		}
 		return config
 	}
@@ -171,6 +406,28 @@ func UpdateConfigWithKnownValues(config *tfjson.Expression, refValue map[string]
 	return config
 }
 
+// singleReference returns the reference of an expression which refers to a single value, like `azurerm_resource_group.rg.name`.
+// The references of such an expression are the full reference and the objects containing it, like `azurerm_resource_group.rg`.
+// The references don't tell a template, like `"${azurerm_resource_group.rg.name}-vnet"`, apart from the reference, so it's only used for
+// the expressions known to be a single reference, see UpdateConfigWithKnownValues.
+func singleReference(references []string) (string, bool) {
+	full := ""
+	for _, reference := range references {
+		if len(reference) > len(full) {
+			full = reference
+		}
+	}
+	for _, reference := range references {
+		if !strings.HasPrefix(full, reference) {
+			return "", false
+		}
+		if rest := full[len(reference):]; rest != "" && rest[0] != '.' && rest[0] != '[' {
+			return "", false
+		}
+	}
+	return full, full != ""
+}
+
 func listDependsOn(config *tfjson.Expression) []string {
 	if config == nil {
 		return nil
@@ -193,22 +450,11 @@ func listDependsOn(config *tfjson.Expression) []string {
 	return dependsOn
 }
 
-func PlannedValue(input interface{}, config *tfjson.Expression, valueType tftypes.Type, path string) interface{} {
-	if input == nil {
-		if config == nil {
-			if pathPlaceholder := placeholder.ForPath(path); pathPlaceholder != nil {
-				return pathPlaceholder
-			}
-		} else {
-			if config.ExpressionData.ConstantValue != nil && config.ExpressionData.ConstantValue != tfjson.UnknownConstantValue {
-				return config.ExpressionData.ConstantValue
-			} else if pathPlaceholder := placeholder.ForPath(path); pathPlaceholder != nil {
-				return pathPlaceholder
-			} else if refPlaceholder := placeholder.ForUnknownReference(config.References, valueType); refPlaceholder != nil {
-				return refPlaceholder
-			}
-			return fmt.Sprintf("%s-%s", path, "unknown")
-		}
+// PlannedValue fills the planned value with the values which are unknown until apply, the `unknown` structure is the matching `after_unknown` of the plan.
+// Only the unknown values are filled, from the known values in the configuration or with placeholders, the null values stay null.
+func PlannedValue(input interface{}, unknown interface{}, config *tfjson.Expression, valueType tftypes.Type, path string) interface{} {
+	if unknown == true {
+		return unknownValue(config, valueType, path)
 	}
 	switch v := input.(type) {
 	case map[string]interface{}:
@@ -222,53 +468,69 @@ func PlannedValue(input interface{}, config *tfjson.Expression, valueType tftype
 				objectType = &objType
 			}
 		}
+		unknownObject, _ := unknown.(map[string]interface{})
 		for key, value := range v {
 			var vType tftypes.Type
 			if objectType != nil {
 				vType = objectType.AttributeTypes[key]
 			}
-			v[key] = PlannedValue(value, nestedBlock[key], vType, fmt.Sprintf("%s.%s", path, key))
+			v[key] = PlannedValue(value, unknownObject[key], nestedBlock[key], vType, fmt.Sprintf("%s.%s", path, key))
 		}
-		for key, value := range nestedBlock {
-			if v[key] == nil {
-				var vType tftypes.Type
-				if objectType != nil {
-					vType = objectType.AttributeTypes[key]
-				}
-				v[key] = PlannedValue(nil, value, vType, fmt.Sprintf("%s.%s", path, key))
+		// the unknown values are omitted from the planned value, only the ones set in the configuration are filled,
+		// the computed attributes are left to the provider
+		for key, value := range unknownObject {
+			if _, ok := v[key]; ok || value != true {
+				continue
 			}
+			keyPath := fmt.Sprintf("%s.%s", path, key)
+			if _, ok := nestedBlock[key]; !ok && placeholder.ForPath(keyPath) == nil {
+				continue
+			}
+			var vType tftypes.Type
+			if objectType != nil {
+				vType = objectType.AttributeTypes[key]
+			}
+			v[key] = unknownValue(nestedBlock[key], vType, keyPath)
 		}
 		return v
 	case []interface{}:
-		if config == nil {
-			return v
+		unknownList, _ := unknown.([]interface{})
+		var elementType tftypes.Type
+		if valueType != nil {
+			if listType, ok := valueType.(tftypes.List); ok {
+				elementType = listType.ElementType
+			}
+			if tupleType, ok := valueType.(tftypes.Tuple); ok && len(tupleType.ElementTypes) > 0 {
+				elementType = tupleType.ElementTypes[0]
+			}
+			if setType, ok := valueType.(tftypes.Set); ok {
+				elementType = setType.ElementType
+			}
 		}
-		if len(config.NestedBlocks) > 0 {
-			var elementType tftypes.Type
-			if valueType != nil {
-				if listType, ok := valueType.(tftypes.List); ok {
-					elementType = listType.ElementType
-				}
-				if tupleType, ok := valueType.(tftypes.Tuple); ok {
-					elementType = tupleType.ElementTypes[0]
-				}
-				if setType, ok := valueType.(tftypes.Set); ok {
-					elementType = setType.ElementType
+		if config == nil || len(config.NestedBlocks) == 0 {
+			// the unknown elements of an attribute can only be filled from the references of the whole attribute
+			for index := range v {
+				if index < len(unknownList) && unknownList[index] == true {
+					v[index] = unknownValue(elementConfig(config, index), elementType, fmt.Sprintf("%s.%d", path, index))
 				}
 			}
-
-			for index, value := range v {
-				nestedBlock := config.NestedBlocks[0]
-				if index < len(config.NestedBlocks) {
-					nestedBlock = config.NestedBlocks[index]
-				}
-
-				v[index] = PlannedValue(value, &tfjson.Expression{
-					ExpressionData: &tfjson.ExpressionData{
-						NestedBlocks: []map[string]*tfjson.Expression{nestedBlock},
-					},
-				}, elementType, fmt.Sprintf("%s.%d", path, 0))
+			return v
+		}
+		for index, value := range v {
+			nestedBlock := config.NestedBlocks[0]
+			if index < len(config.NestedBlocks) {
+				nestedBlock = config.NestedBlocks[index]
+			}
+			var elementUnknown interface{}
+			if index < len(unknownList) {
+				elementUnknown = unknownList[index]
 			}
+
+			v[index] = PlannedValue(value, elementUnknown, &tfjson.Expression{
+				ExpressionData: &tfjson.ExpressionData{
+					NestedBlocks: []map[string]*tfjson.Expression{nestedBlock},
+				},
+			}, elementType, fmt.Sprintf("%s.%d", path, index))
 		}
 		return v
 	default:
@@ -276,72 +538,253 @@ func PlannedValue(input interface{}, config *tfjson.Expression, valueType tftype
 	}
 }
 
-func FindConfigModule(input *tfjson.ConfigModule, address string) *tfjson.Expression {
-	parts := strings.Split(address, ".")
-	if parts[0] == "module" {
-		for moduleName, moduleCall := range input.ModuleCalls {
-			if moduleName == parts[1] {
-				return FindConfigModule(moduleCall.Module, strings.Join(parts[2:], "."))
-			}
+// elementConfig returns the configuration of an element of a list attribute: the element of its known value,
+// like the value of `[azurerm_user_assigned_identity.test.id]` set by UpdateConfigWithKnownValues, and the references of the attribute.
+func elementConfig(config *tfjson.Expression, index int) *tfjson.Expression {
+	if config == nil || config.ExpressionData == nil {
+		return config
+	}
+	out := &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{References: config.References}}
+	switch v := config.ConstantValue.(type) {
+	case []string:
+		if index < len(v) {
+			out.ConstantValue = v[index]
+		}
+	case []interface{}:
+		if index < len(v) {
+			out.ConstantValue = v[index]
 		}
+	}
+	return out
+}
 
-		return nil
+// unknownValue returns the value used for an unknown value: the known value in the configuration, or a placeholder.
+func unknownValue(config *tfjson.Expression, valueType tftypes.Type, path string) interface{} {
+	if config != nil && config.ExpressionData != nil && config.ConstantValue != nil && config.ConstantValue != tfjson.UnknownConstantValue {
+		return config.ConstantValue
+	}
+	if pathPlaceholder := placeholder.ForPath(path); pathPlaceholder != nil {
+		return pathPlaceholder
+	}
+	if config != nil && config.ExpressionData != nil {
+		if refPlaceholder := placeholder.ForUnknownReference(config.References, valueType); refPlaceholder != nil {
+			return refPlaceholder
+		}
+	}
+	return fmt.Sprintf("%s-%s", path, "unknown")
+}
+
+// TopoSortRequests sorts the requests so that a request comes after the requests it depends on, it runs in linear time.
+// The requests keep their input order within a dependency level, and the requests in a dependency cycle are put together, see TopoSortLevels.
+func TopoSortRequests(requests []ApplyRequest) []ApplyRequest {
+	sortedRequests := make([]ApplyRequest, 0, len(requests))
+	for _, level := range TopoSortLevels(requests) {
+		sortedRequests = append(sortedRequests, level...)
 	}
+	return sortedRequests
+}
 
-	for _, resource := range input.Resources {
-		if resource.Address == address {
-			return &tfjson.Expression{
-				ExpressionData: &tfjson.ExpressionData{
-					NestedBlocks: []map[string]*tfjson.Expression{
-						resource.Expressions,
-					},
-				},
+// TopoSortLevels groups the requests by their dependency levels. The requests of a level only depend on the requests of the previous levels,
+// so they can be applied concurrently. The requests keep their input order within a level. The requests in a dependency cycle are put in one level
+// after the requests the cycle depends on, and the requests depending on the cycle are put in the following levels.
+func TopoSortLevels(requests []ApplyRequest) [][]ApplyRequest {
+	deps := dependencies(requests)
+	inDegree := make([]int, len(requests))
+	dependents := make([][]int, len(requests))
+	for i := range deps {
+		for _, j := range deps[i] {
+			if j != i {
+				inDegree[i]++
+				dependents[j] = append(dependents[j], i)
 			}
 		}
 	}
 
-	return nil
+	levels := make([][]ApplyRequest, 0)
+	queued := make([]bool, len(requests))
+	current := make([]int, 0)
+	for i := range requests {
+		if inDegree[i] == 0 {
+			queued[i] = true
+			current = append(current, i)
+		}
+	}
+	var cycles [][]int
+	var cycleOf map[int]int
+	for visited := 0; visited < len(requests); {
+		if len(current) == 0 {
+			// the remaining requests are in dependency cycles or depend on them
+			if cycles == nil {
+				cycles = cycleComponents(deps)
+				cycleOf = make(map[int]int)
+				for index, cycle := range cycles {
+					for _, i := range cycle {
+						cycleOf[i] = index
+					}
+				}
+			}
+			current = readyCycles(cycles, cycleOf, deps, queued)
+			if len(current) == 0 {
+				// unreachable, one of the remaining cycles only depends on the previous levels
+				for i := range requests {
+					if !queued[i] {
+						current = append(current, i)
+					}
+				}
+			}
+			for _, i := range current {
+				queued[i] = true
+			}
+		}
+		sort.Ints(current)
+		level := make([]ApplyRequest, 0, len(current))
+		next := make([]int, 0)
+		for _, i := range current {
+			level = append(level, requests[i])
+			for _, dependent := range dependents[i] {
+				inDegree[dependent]--
+				if inDegree[dependent] == 0 && !queued[dependent] {
+					queued[dependent] = true
+					next = append(next, dependent)
+				}
+			}
+		}
+		visited += len(current)
+		levels = append(levels, level)
+		current = next
+	}
+	return levels
 }
 
-func TopoSortRequests(requests []ApplyRequest) []ApplyRequest {
-	inDegree := make(map[string]int)
-	graph := make(map[string][]string)
-	for _, request := range requests {
-		inDegree[request.Address] = 0
-		graph[request.Address] = make([]string, 0)
-	}
-	for _, request := range requests {
-		for _, dep := range request.DependsOn {
-			if _, ok := inDegree[dep]; ok {
-				inDegree[request.Address]++
-				graph[dep] = append(graph[dep], request.Address)
+// readyCycles returns the requests of the dependency cycles which aren't queued yet and only depend on the queued requests and on themselves.
+// The cycleOf map is the index of the cycle of each request in a cycle.
+func readyCycles(cycles [][]int, cycleOf map[int]int, deps [][]int, queued []bool) []int {
+	out := make([]int, 0)
+	for index, cycle := range cycles {
+		if queued[cycle[0]] {
+			continue
+		}
+		ready := true
+		for _, i := range cycle {
+			for _, j := range deps[i] {
+				if other, ok := cycleOf[j]; !queued[j] && (!ok || other != index) {
+					ready = false
+				}
 			}
 		}
+		if ready {
+			out = append(out, cycle...)
+		}
 	}
-	queue := make([]string, 0)
-	for address, count := range inDegree {
-		if count == 0 {
-			queue = append(queue, address)
+	return out
+}
+
+// FindCycles returns the addresses of the requests in each dependency cycle, including the requests that reference themselves.
+// The addresses of a cycle are in the input order, and the cycles are ordered by their first request, so the result is deterministic.
+// It finds the strongly connected components of the dependency graph with Tarjan's algorithm, in linear time.
+func FindCycles(requests []ApplyRequest) [][]string {
+	components := cycleComponents(dependencies(requests))
+	out := make([][]string, 0, len(components))
+	for _, component := range components {
+		addresses := make([]string, 0, len(component))
+		for _, i := range component {
+			addresses = append(addresses, requests[i].Address)
 		}
+		out = append(out, addresses)
 	}
-	sortedRequests := make([]ApplyRequest, 0)
-	for len(queue) > 0 {
-		address := queue[0]
-		queue = queue[1:]
+	return out
+}
 
-		for _, request := range graph[address] {
-			inDegree[request]--
-			if inDegree[request] == 0 {
-				queue = append(queue, request)
+// cycleComponents returns the indexes of the requests in each dependency cycle, see FindCycles.
+func cycleComponents(deps [][]int) [][]int {
+	index := make([]int, len(deps))
+	lowlink := make([]int, len(deps))
+	onStack := make([]bool, len(deps))
+	for i := range index {
+		index[i] = -1
+	}
+	stack := make([]int, 0)
+	counter := 0
+	components := make([][]int, 0)
+
+	// the depth-first search is iterative, so deep dependency chains don't overflow the goroutine stack
+	type frame struct {
+		node int
+		edge int
+	}
+	visit := func(node int) frame {
+		index[node] = counter
+		lowlink[node] = counter
+		counter++
+		stack = append(stack, node)
+		onStack[node] = true
+		return frame{node: node}
+	}
+	for start := range deps {
+		if index[start] != -1 {
+			continue
+		}
+		frames := []frame{visit(start)}
+		for len(frames) > 0 {
+			current := &frames[len(frames)-1]
+			if current.edge < len(deps[current.node]) {
+				next := deps[current.node][current.edge]
+				current.edge++
+				if index[next] == -1 {
+					frames = append(frames, visit(next))
+				} else if onStack[next] {
+					lowlink[current.node] = min(lowlink[current.node], index[next])
+				}
+				continue
+			}
+
+			node := current.node
+			frames = frames[:len(frames)-1]
+			if len(frames) > 0 {
+				parent := frames[len(frames)-1].node
+				lowlink[parent] = min(lowlink[parent], lowlink[node])
+			}
+			if lowlink[node] != index[node] {
+				continue
+			}
+			component := make([]int, 0)
+			for {
+				last := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[last] = false
+				component = append(component, last)
+				if last == node {
+					break
+				}
+			}
+			if len(component) > 1 || slices.Contains(deps[node], node) {
+				sort.Ints(component)
+				components = append(components, component)
 			}
 		}
+	}
 
-		for _, request := range requests {
-			if request.Address == address {
-				sortedRequests = append(sortedRequests, request)
-				break
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+// dependencies returns the indexes of the requests that each request depends on, without duplicates.
+func dependencies(requests []ApplyRequest) [][]int {
+	indexes := make(map[string]int, len(requests))
+	for i, request := range requests {
+		indexes[request.Address] = i
+	}
+	out := make([][]int, len(requests))
+	for i, request := range requests {
+		seen := make(map[int]bool)
+		for _, dep := range request.DependsOn {
+			if j, ok := indexes[dep]; ok && !seen[j] {
+				seen[j] = true
+				out[i] = append(out[i], j)
 			}
 		}
 	}
-	return sortedRequests
+	return out
 }
@@ -10,7 +10,7 @@ import (
 	"testing"
 
 	"github.com/Azure/aztfpreflight/internal/plan"
-	"github.com/Azure/aztfpreflight/internal/tfclient"
+	"github.com/Azure/aztfpreflight/internal/terraform"
 	"github.com/Azure/aztfpreflight/internal/types"
 	"github.com/hashicorp/terraform-exec/tfexec"
 )
@@ -38,7 +38,7 @@ func Test_AzureRMResourceCoverage(t *testing.T) {
 	tested := make(map[string]bool)
 	success := make(map[string]bool)
 
-	tfexecPath, err := tfclient.FindTerraform(context.TODO())
+	tfexecPath, err := terraform.FindTerraform(context.TODO())
 	if err != nil {
 		t.Fatalf("Failed to find terraform executable: %v", err)
 	}
@@ -75,7 +75,7 @@ func Test_AzureRMResourceCoverage(t *testing.T) {
 			continue
 		}
 
-		models := plan.ExportAzurePayload(tfplan)
+		models := plan.ExportAzurePayload(tfplan, plan.Options{})
 		for _, model := range models {
 			resourceType := strings.Split(model.Address, ".")[0]
 			if model.Failed != nil {
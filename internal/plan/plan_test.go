@@ -2,16 +2,196 @@ package plan_test
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 	"strings"
 
+	"github.com/Azure/aztfpreflight/internal/placeholder"
 	"github.com/Azure/aztfpreflight/internal/plan"
-	"github.com/Azure/aztfpreflight/internal/tfclient"
+	"github.com/Azure/aztfpreflight/internal/terraform"
+	"github.com/Azure/aztfpreflight/internal/types"
 	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
 )
 
+func Test_ChangeAction(t *testing.T) {
+	testcases := []struct {
+		Actions tfjson.Actions
+		Expect  types.Action
+	}{
+		{
+			Actions: tfjson.Actions{tfjson.ActionCreate},
+			Expect:  types.ActionCreate,
+		},
+		{
+			Actions: tfjson.Actions{tfjson.ActionUpdate},
+			Expect:  types.ActionUpdate,
+		},
+		{
+			Actions: tfjson.Actions{tfjson.ActionDelete, tfjson.ActionCreate},
+			Expect:  types.ActionReplace,
+		},
+		{
+			Actions: tfjson.Actions{tfjson.ActionCreate, tfjson.ActionDelete},
+			Expect:  types.ActionReplace,
+		},
+		{
+			Actions: tfjson.Actions{tfjson.ActionDelete},
+			Expect:  "",
+		},
+		{
+			Actions: tfjson.Actions{tfjson.ActionNoop},
+			Expect:  "",
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual := plan.ChangeAction(testcase.Actions)
+		if actual != testcase.Expect {
+			t.Fatalf("Expected action %q for %v, got %q", testcase.Expect, testcase.Actions, actual)
+		}
+	}
+}
+
+func Test_HasReplaceConflict(t *testing.T) {
+	testcases := []struct {
+		Before interface{}
+		URL    string
+		Expect bool
+	}{
+		{
+			Before: map[string]interface{}{
+				"id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test/providers/Microsoft.Storage/storageAccounts/test",
+			},
+			URL:    "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/test/providers/Microsoft.Storage/storageAccounts/test?api-version=2023-01-01",
+			Expect: true,
+		},
+		{
+			Before: map[string]interface{}{
+				"id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test/providers/Microsoft.Storage/storageAccounts/test",
+			},
+			URL:    "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test/providers/Microsoft.Storage/storageAccounts/test2?api-version=2023-01-01",
+			Expect: false,
+		},
+		{
+			Before: nil,
+			URL:    "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test?api-version=2020-06-01",
+			Expect: false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual := plan.HasReplaceConflict(testcase.Before, testcase.URL)
+		if actual != testcase.Expect {
+			t.Fatalf("Expected %v for url %s, got %v", testcase.Expect, testcase.URL, actual)
+		}
+	}
+}
+
+func Test_PlannedValue(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Input   interface{}
+		Unknown interface{}
+		Config  map[string]*tfjson.Expression
+		Expect  interface{}
+	}{
+		{
+			Name:    "null attributes stay null",
+			Input:   map[string]interface{}{"name": "test", "tags": nil},
+			Unknown: map[string]interface{}{"id": true},
+			Config: map[string]*tfjson.Expression{
+				"name": {ExpressionData: &tfjson.ExpressionData{ConstantValue: "test"}},
+				"tags": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.tags"}}},
+			},
+			Expect: map[string]interface{}{"name": "test", "tags": nil},
+		},
+		{
+			Name:    "unknown attributes are filled",
+			Input:   map[string]interface{}{"name": "test"},
+			Unknown: map[string]interface{}{"id": true, "user_assigned_identity_id": true},
+			Config: map[string]*tfjson.Expression{
+				"name": {ExpressionData: &tfjson.ExpressionData{ConstantValue: "test"}},
+				"user_assigned_identity_id": {ExpressionData: &tfjson.ExpressionData{
+					ConstantValue: tfjson.UnknownConstantValue,
+					References:    []string{"azurerm_user_assigned_identity.test.id", "azurerm_user_assigned_identity.test"},
+				}},
+			},
+			Expect: map[string]interface{}{
+				"name":                      "test",
+				"user_assigned_identity_id": placeholder.ForResourceTypePath("azurerm_user_assigned_identity", "id"),
+			},
+		},
+		{
+			Name:    "unknown attributes without placeholder",
+			Input:   map[string]interface{}{},
+			Unknown: map[string]interface{}{"description": true},
+			Config: map[string]*tfjson.Expression{
+				"description": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"random_string.test.result"}}},
+			},
+			Expect: map[string]interface{}{"description": "azurerm_resource.description-unknown"},
+		},
+		{
+			Name:    "unknown list elements",
+			Input:   map[string]interface{}{"ids": []interface{}{"known", nil}},
+			Unknown: map[string]interface{}{"ids": []interface{}{false, true}},
+			Config: map[string]*tfjson.Expression{
+				"ids": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.unknown"}}},
+			},
+			Expect: map[string]interface{}{"ids": []interface{}{"known", "azurerm_resource.ids.1-unknown"}},
+		},
+		{
+			Name:    "unknown list elements of a known reference",
+			Input:   map[string]interface{}{"identity_ids": []interface{}{nil}},
+			Unknown: map[string]interface{}{"identity_ids": []interface{}{true}},
+			Config: map[string]*tfjson.Expression{
+				// the value of `[azurerm_user_assigned_identity.test.id]` set by UpdateConfigWithKnownValues
+				"identity_ids": {ExpressionData: &tfjson.ExpressionData{ConstantValue: []string{"/subscriptions/000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test"}}},
+			},
+			Expect: map[string]interface{}{"identity_ids": []interface{}{"/subscriptions/000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test"}},
+		},
+		{
+			Name: "unknown attributes of nested blocks",
+			Input: map[string]interface{}{"block": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b"},
+			}},
+			Unknown: map[string]interface{}{"block": []interface{}{
+				map[string]interface{}{"subnet_id": true},
+				map[string]interface{}{"subnet_id": true},
+			}},
+			Config: map[string]*tfjson.Expression{
+				"block": {ExpressionData: &tfjson.ExpressionData{NestedBlocks: []map[string]*tfjson.Expression{
+					{"subnet_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.a"}}}},
+					{"subnet_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.b"}}}},
+				}}},
+			},
+			Expect: map[string]interface{}{"block": []interface{}{
+				map[string]interface{}{"name": "a", "subnet_id": "azurerm_resource.block.0.subnet_id-unknown"},
+				map[string]interface{}{"name": "b", "subnet_id": "azurerm_resource.block.1.subnet_id-unknown"},
+			}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			config := &tfjson.Expression{
+				ExpressionData: &tfjson.ExpressionData{
+					NestedBlocks: []map[string]*tfjson.Expression{testcase.Config},
+				},
+			}
+			actual := plan.PlannedValue(testcase.Input, testcase.Unknown, config, nil, "azurerm_resource")
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, actual)
+			}
+		})
+	}
+}
+
 func Test_TopoSortRequests(t *testing.T) {
 	testcases := []struct {
 		Input  []plan.ApplyRequest
@@ -70,6 +250,40 @@ func Test_TopoSortRequests(t *testing.T) {
 				},
 			},
 		},
+		{
+			Input: []plan.ApplyRequest{
+				{
+					Address:   "azurerm_storage_account.a",
+					DependsOn: []string{"azurerm_storage_account.b"},
+				},
+				{
+					Address:   "azurerm_storage_account.b",
+					DependsOn: []string{"azurerm_storage_account.a"},
+				},
+				{
+					Address:   "azurerm_storage_account.self",
+					DependsOn: []string{"azurerm_storage_account.self", "azurerm_resource_group.test"},
+				},
+				{
+					Address:   "azurerm_resource_group.test",
+					DependsOn: nil,
+				},
+			},
+			Output: []plan.ApplyRequest{
+				{
+					Address: "azurerm_resource_group.test",
+				},
+				{
+					Address: "azurerm_storage_account.self",
+				},
+				{
+					Address: "azurerm_storage_account.a",
+				},
+				{
+					Address: "azurerm_storage_account.b",
+				},
+			},
+		},
 	}
 
 	for _, testcase := range testcases {
@@ -87,6 +301,267 @@ func Test_TopoSortRequests(t *testing.T) {
 	}
 }
 
+func Test_TopoSortLevels(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Input  []plan.ApplyRequest
+		Output [][]string
+	}{
+		{
+			Name: "independent resources in one level",
+			Input: []plan.ApplyRequest{
+				{Address: "azurerm_storage_account.b"},
+				{Address: "azurerm_storage_account.a"},
+			},
+			Output: [][]string{
+				{"azurerm_storage_account.b", "azurerm_storage_account.a"},
+			},
+		},
+		{
+			Name: "dependencies in later levels",
+			Input: []plan.ApplyRequest{
+				{
+					Address:   "azurerm_synapse_workspace.test",
+					DependsOn: []string{"azurerm_storage_account.test.id", "azurerm_storage_account.test", "azurerm_resource_group.test"},
+				},
+				{
+					Address:   "azurerm_storage_account.test",
+					DependsOn: []string{"azurerm_resource_group.test.name", "azurerm_resource_group.test"},
+				},
+				{
+					Address:   "azurerm_virtual_network.test",
+					DependsOn: []string{"azurerm_resource_group.test"},
+				},
+				{
+					Address: "azurerm_resource_group.test",
+				},
+			},
+			Output: [][]string{
+				{"azurerm_resource_group.test"},
+				{"azurerm_storage_account.test", "azurerm_virtual_network.test"},
+				{"azurerm_synapse_workspace.test"},
+			},
+		},
+		{
+			Name: "cycle in the last level",
+			Input: []plan.ApplyRequest{
+				{
+					Address:   "azurerm_storage_account.a",
+					DependsOn: []string{"azurerm_storage_account.b"},
+				},
+				{
+					Address:   "azurerm_storage_account.b",
+					DependsOn: []string{"azurerm_storage_account.a"},
+				},
+				{
+					Address: "azurerm_resource_group.test",
+				},
+			},
+			Output: [][]string{
+				{"azurerm_resource_group.test"},
+				{"azurerm_storage_account.a", "azurerm_storage_account.b"},
+			},
+		},
+		{
+			Name: "dependents of a cycle after the cycle",
+			Input: []plan.ApplyRequest{
+				{
+					Address:   "azurerm_private_endpoint.test",
+					DependsOn: []string{"azurerm_storage_account.a"},
+				},
+				{
+					Address:   "azurerm_storage_account.a",
+					DependsOn: []string{"azurerm_storage_account.b", "azurerm_resource_group.test"},
+				},
+				{
+					Address:   "azurerm_storage_account.b",
+					DependsOn: []string{"azurerm_storage_account.a"},
+				},
+				{
+					Address: "azurerm_resource_group.test",
+				},
+				{
+					Address:   "azurerm_private_dns_zone.test",
+					DependsOn: []string{"azurerm_private_endpoint.test"},
+				},
+			},
+			Output: [][]string{
+				{"azurerm_resource_group.test"},
+				{"azurerm_storage_account.a", "azurerm_storage_account.b"},
+				{"azurerm_private_endpoint.test"},
+				{"azurerm_private_dns_zone.test"},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			levels := plan.TopoSortLevels(testcase.Input)
+			actual := make([][]string, 0)
+			for _, level := range levels {
+				addresses := make([]string, 0)
+				for _, request := range level {
+					addresses = append(addresses, request.Address)
+				}
+				actual = append(actual, addresses)
+			}
+			if !reflect.DeepEqual(actual, testcase.Output) {
+				t.Fatalf("Expected levels %v, got %v", testcase.Output, actual)
+			}
+		})
+	}
+}
+
+func Test_FindCycles(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Input  []plan.ApplyRequest
+		Output [][]string
+	}{
+		{
+			Name: "no cycle",
+			Input: []plan.ApplyRequest{
+				{Address: "azurerm_storage_account.test", DependsOn: []string{"azurerm_resource_group.test"}},
+				{Address: "azurerm_resource_group.test"},
+			},
+			Output: [][]string{},
+		},
+		{
+			Name: "cycles and self-references",
+			Input: []plan.ApplyRequest{
+				{Address: "azurerm_storage_account.c", DependsOn: []string{"azurerm_storage_account.a"}},
+				{Address: "azurerm_storage_account.self", DependsOn: []string{"azurerm_storage_account.self.id", "azurerm_storage_account.self"}},
+				{Address: "azurerm_storage_account.a", DependsOn: []string{"azurerm_storage_account.b"}},
+				{Address: "azurerm_storage_account.b", DependsOn: []string{"azurerm_storage_account.c", "azurerm_resource_group.test"}},
+				{Address: "azurerm_resource_group.test"},
+				{Address: "azurerm_virtual_network.x", DependsOn: []string{"azurerm_virtual_network.y", "azurerm_resource_group.test"}},
+				{Address: "azurerm_virtual_network.y", DependsOn: []string{"azurerm_virtual_network.x"}},
+			},
+			Output: [][]string{
+				{"azurerm_storage_account.c", "azurerm_storage_account.a", "azurerm_storage_account.b"},
+				{"azurerm_storage_account.self"},
+				{"azurerm_virtual_network.x", "azurerm_virtual_network.y"},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			actual := plan.FindCycles(testcase.Input)
+			if !reflect.DeepEqual(actual, testcase.Output) {
+				t.Fatalf("Expected cycles %v, got %v", testcase.Output, actual)
+			}
+		})
+	}
+}
+
+func Test_FindCycles_LongChain(t *testing.T) {
+	requests := make([]plan.ApplyRequest, 0)
+	for i := 0; i < 100000; i++ {
+		request := plan.ApplyRequest{Address: fmt.Sprintf("azurerm_resource_group.r%d", i)}
+		if i > 0 {
+			request.DependsOn = []string{fmt.Sprintf("azurerm_resource_group.r%d", i-1)}
+		}
+		requests = append(requests, request)
+	}
+	requests[0].DependsOn = []string{fmt.Sprintf("azurerm_resource_group.r%d", len(requests)-1)}
+
+	cycles := plan.FindCycles(requests)
+	if len(cycles) != 1 || len(cycles[0]) != len(requests) {
+		t.Fatalf("Expected one cycle of %d requests, got %d cycles", len(requests), len(cycles))
+	}
+	if sorted := plan.TopoSortRequests(requests); len(sorted) != len(requests) {
+		t.Fatalf("Expected %d requests, got %d", len(requests), len(sorted))
+	}
+}
+
+func Test_IsAzurermProvider(t *testing.T) {
+	testcases := []struct {
+		ProviderName string
+		Sources      []string
+		Expect       bool
+	}{
+		{
+			ProviderName: "registry.terraform.io/hashicorp/azurerm",
+			Expect:       true,
+		},
+		{
+			ProviderName: "registry.opentofu.org/hashicorp/azurerm",
+			Expect:       true,
+		},
+		{
+			ProviderName: "mirror.example.com/hashicorp/azurerm",
+			Expect:       true,
+		},
+		{
+			ProviderName: "registry.terraform.io/azure/azapi",
+			Expect:       false,
+		},
+		{
+			ProviderName: "registry.opentofu.org/hashicorp/azurerm",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm"},
+			Expect:       false,
+		},
+		{
+			ProviderName: "mirror.example.com/hashicorp/azurerm",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm", "mirror.example.com/hashicorp/azurerm"},
+			Expect:       true,
+		},
+		{
+			ProviderName: "example.com/myorg/azurerm-fork",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm", "example.com/myorg/azurerm-fork"},
+			Expect:       false,
+		},
+		{
+			ProviderName: "registry.terraform.io/hashicorp/azuread",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm", "registry.terraform.io/hashicorp/azuread"},
+			Expect:       false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual := plan.IsAzurermProvider(testcase.ProviderName, testcase.Sources)
+		if actual != testcase.Expect {
+			t.Fatalf("Expected %v for provider %q with sources %v, got %v", testcase.Expect, testcase.ProviderName, testcase.Sources, actual)
+		}
+	}
+}
+
+func Test_IsAzapiProvider(t *testing.T) {
+	testcases := []struct {
+		ProviderName string
+		Sources      []string
+		Expect       bool
+	}{
+		{
+			ProviderName: "registry.terraform.io/azure/azapi",
+			Expect:       true,
+		},
+		{
+			ProviderName: "registry.terraform.io/azure/azapi",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm"},
+			Expect:       false,
+		},
+		{
+			ProviderName: "registry.terraform.io/azure/azapi",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm", "registry.terraform.io/azure/azapi"},
+			Expect:       true,
+		},
+		{
+			ProviderName: "registry.terraform.io/hashicorp/azurerm",
+			Sources:      []string{"registry.terraform.io/hashicorp/azurerm", "registry.terraform.io/azure/azapi"},
+			Expect:       false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual := plan.IsAzapiProvider(testcase.ProviderName, testcase.Sources)
+		if actual != testcase.Expect {
+			t.Fatalf("Expected %v for provider %q with sources %v, got %v", testcase.Expect, testcase.ProviderName, testcase.Sources, actual)
+		}
+	}
+}
+
 func Test_ExportAzurePayload(t *testing.T) {
 	t.Setenv("ARM_SUBSCRIPTION_ID", "00000000-0000-0000-0000-000000000000")
 	testcases := []struct {
@@ -99,7 +574,7 @@ func Test_ExportAzurePayload(t *testing.T) {
 		},
 	}
 
-	tfexecPath, err := tfclient.FindTerraform(context.TODO())
+	tfexecPath, err := terraform.FindTerraform(context.TODO())
 	if err != nil {
 		t.Fatalf("Failed to find terraform: %v", err)
 	}
@@ -131,7 +606,7 @@ func Test_ExportAzurePayload(t *testing.T) {
 		    t.Fatal(err)
 	    }
 
-		models := plan.ExportAzurePayload(tfplan)
+		models := plan.ExportAzurePayload(tfplan, plan.Options{})
 		if len(models) != testcase.ModelCount {
 			t.Fatalf("Expected %d models, got %d", testcase.ModelCount, len(models))
 		}
@@ -146,3 +621,86 @@ func Test_ExportAzurePayload(t *testing.T) {
 		}
 	}
 }
+
+func Test_ExportAzurePayload_WithoutConfiguration(t *testing.T) {
+	t.Setenv("ARM_SUBSCRIPTION_ID", "00000000-0000-0000-0000-000000000000")
+	tfplan := &tfjson.Plan{
+		ResourceChanges: []*tfjson.ResourceChange{
+			{
+				Address:      "azurerm_resource_group.test",
+				Mode:         tfjson.ManagedResourceMode,
+				Type:         "azurerm_resource_group",
+				Name:         "test",
+				ProviderName: "registry.terraform.io/hashicorp/azurerm",
+				Change: &tfjson.Change{
+					Actions:      tfjson.Actions{tfjson.ActionCreate},
+					After:        map[string]interface{}{"name": "test", "location": "westeurope"},
+					AfterUnknown: map[string]interface{}{"id": true},
+				},
+			},
+		},
+	}
+
+	models := plan.ExportAzurePayload(tfplan, plan.Options{})
+	if len(models) != 1 || models[0].Address != "azurerm_resource_group.test" {
+		t.Fatalf("Expected the model of azurerm_resource_group.test, got %v", models)
+	}
+}
+
+func Test_ExportAzurePayload_UpdateReadsExistingResource(t *testing.T) {
+	t.Setenv("ARM_SUBSCRIPTION_ID", "00000000-0000-0000-0000-000000000000")
+	vnetId := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	before := map[string]interface{}{
+		"id":                  vnetId,
+		"name":                "vnet",
+		"resource_group_name": "rg",
+		"location":            "westeurope",
+		"address_space":       []interface{}{"10.0.0.0/16"},
+		"tags":                map[string]interface{}{"env": "dev"},
+	}
+	after := make(map[string]interface{})
+	for key, value := range before {
+		after[key] = value
+	}
+	after["tags"] = map[string]interface{}{"env": "prod"}
+	tfplan := &tfjson.Plan{
+		PriorState: &tfjson.State{
+			Values: &tfjson.StateValues{
+				RootModule: &tfjson.StateModule{
+					Resources: []*tfjson.StateResource{
+						{
+							Address:         "azurerm_virtual_network.test",
+							Mode:            tfjson.ManagedResourceMode,
+							Type:            "azurerm_virtual_network",
+							Name:            "test",
+							AttributeValues: before,
+						},
+					},
+				},
+			},
+		},
+		ResourceChanges: []*tfjson.ResourceChange{
+			{
+				Address:      "azurerm_virtual_network.test",
+				Mode:         tfjson.ManagedResourceMode,
+				Type:         "azurerm_virtual_network",
+				Name:         "test",
+				ProviderName: "registry.terraform.io/hashicorp/azurerm",
+				Change: &tfjson.Change{
+					Actions: tfjson.Actions{tfjson.ActionUpdate},
+					Before:  before,
+					After:   after,
+				},
+			},
+		},
+	}
+
+	// the virtual network update reads the existing resource and sends it back with the updated properties
+	models := plan.ExportAzurePayload(tfplan, plan.Options{})
+	if len(models) != 1 || models[0].Method != http.MethodPut {
+		t.Fatalf("Expected the PUT request of azurerm_virtual_network.test, got %v", models)
+	}
+	if body := models[0].Body; !strings.Contains(body, `"10.0.0.0/16"`) || !strings.Contains(body, `"env":"prod"`) {
+		t.Fatalf("Expected the address space of the existing resource and the updated tags in the request body, got %s", body)
+	}
+}
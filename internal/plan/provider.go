@@ -0,0 +1,106 @@
+package plan
+
+import (
+	"encoding/json"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// ProviderConfiguration returns the arguments of the provider configuration of the provider config key, like `azurerm` or `azurerm.west`,
+// in the format of tfclient.NewTerraformClientWithConfig. The arguments which are unknown in the plan are omitted,
+// the references to the variables are resolved with their values in the plan: the variables of a module are followed
+// through the arguments of its module calls to the root module variables. It returns nil if the provider isn't configured.
+func ProviderConfiguration(tfplan *tfjson.Plan, providerConfigKey string) map[string]interface{} {
+	if tfplan == nil || tfplan.Config == nil || tfplan.Config.ProviderConfigs[providerConfigKey] == nil {
+		return nil
+	}
+	providerConfig := tfplan.Config.ProviderConfigs[providerConfigKey]
+	resolver := NewReferenceResolver(tfplan.Config.RootModule)
+	variable := func(name string) (interface{}, bool) {
+		return variableValue(tfplan, resolver, providerConfig.ModuleAddress, name, 0)
+	}
+	out := providerBlockValue(providerConfig.Expressions, variable)
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// variableValue returns the value of a variable of the module, it returns false if it's not known in the plan.
+// The variables of a child module take the arguments of its module call, or their default values if the arguments aren't set.
+// Only the constant arguments and the arguments which are a reference to a whole variable of the parent module are resolved.
+func variableValue(tfplan *tfjson.Plan, resolver *ReferenceResolver, moduleAddress string, name string, depth int) (interface{}, bool) {
+	if depth > maxReferenceDepth {
+		return nil, false
+	}
+	if moduleAddress == "" {
+		variable := tfplan.Variables[name]
+		if variable == nil || variable.Value == nil {
+			return nil, false
+		}
+		return variable.Value, true
+	}
+	parentAddress, callName, _ := splitModuleAddress(moduleAddress)
+	call := resolver.moduleCall(parentAddress, callName)
+	if callName == "" || call == nil {
+		return nil, false
+	}
+	expr := call.Expressions[name]
+	if expr == nil || expr.ExpressionData == nil {
+		if call.Module != nil && call.Module.Variables[name] != nil {
+			if value := call.Module.Variables[name].Default; value != nil && value != tfjson.UnknownConstantValue {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+	if expr.ConstantValue != nil && expr.ConstantValue != tfjson.UnknownConstantValue {
+		return expr.ConstantValue, true
+	}
+	if len(expr.References) == 1 && strings.HasPrefix(expr.References[0], "var.") {
+		return variableValue(tfplan, resolver, parentAddress, strings.TrimPrefix(expr.References[0], "var."), depth+1)
+	}
+	return nil, false
+}
+
+// providerConfigurationKey returns a key of the provider configuration which is the same for the same arguments.
+func providerConfigurationKey(config map[string]interface{}) string {
+	if config == nil {
+		return ""
+	}
+	// the map keys are sorted when they're marshaled
+	out, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func providerBlockValue(block map[string]*tfjson.Expression, variable func(name string) (interface{}, bool)) map[string]interface{} {
+	out := make(map[string]interface{})
+	for key, expr := range block {
+		if expr == nil || expr.ExpressionData == nil {
+			continue
+		}
+		if expr.NestedBlocks != nil {
+			blocks := make([]interface{}, 0, len(expr.NestedBlocks))
+			for _, nestedBlock := range expr.NestedBlocks {
+				blocks = append(blocks, providerBlockValue(nestedBlock, variable))
+			}
+			out[key] = blocks
+			continue
+		}
+		if expr.ConstantValue != nil && expr.ConstantValue != tfjson.UnknownConstantValue {
+			out[key] = expr.ConstantValue
+			continue
+		}
+		// only a reference to a whole variable is resolved, like `subscription_id = var.subscription_id`
+		if len(expr.References) == 1 && strings.HasPrefix(expr.References[0], "var.") {
+			if value, ok := variable(strings.TrimPrefix(expr.References[0], "var.")); ok {
+				out[key] = value
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package plan_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/plan"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_ProviderConfiguration(t *testing.T) {
+	tfplan := &tfjson.Plan{
+		Variables: map[string]*tfjson.PlanVariable{
+			"subscription_id": {Value: "11111111-1111-1111-1111-111111111111"},
+		},
+		Config: &tfjson.Config{
+			ProviderConfigs: map[string]*tfjson.ProviderConfig{
+				"azurerm": {
+					Name: "azurerm",
+					Expressions: map[string]*tfjson.Expression{
+						"features": {ExpressionData: &tfjson.ExpressionData{
+							NestedBlocks: []map[string]*tfjson.Expression{{}},
+						}},
+					},
+				},
+				"azurerm.west": {
+					Name:  "azurerm",
+					Alias: "west",
+					Expressions: map[string]*tfjson.Expression{
+						"subscription_id":     {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.subscription_id"}}},
+						"storage_use_azuread": {ExpressionData: &tfjson.ExpressionData{ConstantValue: true}},
+						"client_id":           {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.client_id"}}},
+						"features": {ExpressionData: &tfjson.ExpressionData{
+							NestedBlocks: []map[string]*tfjson.Expression{
+								{
+									"resource_group": {ExpressionData: &tfjson.ExpressionData{
+										NestedBlocks: []map[string]*tfjson.Expression{
+											{
+												"prevent_deletion_if_contains_resources": {ExpressionData: &tfjson.ExpressionData{ConstantValue: false}},
+											},
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+				"module.net:azurerm": {
+					Name:          "azurerm",
+					ModuleAddress: "module.net",
+					Expressions: map[string]*tfjson.Expression{
+						"subscription_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.subscription_id"}}},
+						"client_id":       {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.client_id"}}},
+					},
+				},
+				"module.net.module.dns:azurerm": {
+					Name:          "azurerm",
+					ModuleAddress: "module.net.module.dns",
+					Expressions: map[string]*tfjson.Expression{
+						"subscription_id":     {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.subscription_id"}}},
+						"storage_use_azuread": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.storage_use_azuread"}}},
+						"environment":         {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.environment"}}},
+					},
+				},
+			},
+			RootModule: &tfjson.ConfigModule{
+				ModuleCalls: map[string]*tfjson.ModuleCall{
+					"net": {
+						Expressions: map[string]*tfjson.Expression{
+							// the argument is passed to the provider through the variable of the root module
+							"subscription_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"var.subscription_id"}}},
+							// the argument references a resource, it's unknown
+							"client_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: []string{"azurerm_user_assigned_identity.test.client_id", "azurerm_user_assigned_identity.test"}}},
+						},
+						Module: &tfjson.ConfigModule{
+							ModuleCalls: map[string]*tfjson.ModuleCall{
+								"dns": {
+									Expressions: map[string]*tfjson.Expression{
+										"subscription_id": {ExpressionData: &tfjson.ExpressionData{ConstantValue: "22222222-2222-2222-2222-222222222222"}},
+									},
+									Module: &tfjson.ConfigModule{
+										Variables: map[string]*tfjson.ConfigVariable{
+											"storage_use_azuread": {Default: true},
+											"environment":         {},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testcases := []struct {
+		ProviderConfigKey string
+		Expect            map[string]interface{}
+	}{
+		{
+			ProviderConfigKey: "azurerm",
+			Expect: map[string]interface{}{
+				"features": []interface{}{map[string]interface{}{}},
+			},
+		},
+		{
+			ProviderConfigKey: "azurerm.west",
+			Expect: map[string]interface{}{
+				"subscription_id":     "11111111-1111-1111-1111-111111111111",
+				"storage_use_azuread": true,
+				"features": []interface{}{
+					map[string]interface{}{
+						"resource_group": []interface{}{
+							map[string]interface{}{"prevent_deletion_if_contains_resources": false},
+						},
+					},
+				},
+			},
+		},
+		{
+			ProviderConfigKey: "module.net:azurerm",
+			Expect: map[string]interface{}{
+				"subscription_id": "11111111-1111-1111-1111-111111111111",
+			},
+		},
+		{
+			ProviderConfigKey: "module.net.module.dns:azurerm",
+			Expect: map[string]interface{}{
+				"subscription_id":     "22222222-2222-2222-2222-222222222222",
+				"storage_use_azuread": true,
+			},
+		},
+		{
+			ProviderConfigKey: "azurerm.missing",
+			Expect:            nil,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.ProviderConfigKey, func(t *testing.T) {
+			actual := plan.ProviderConfiguration(tfplan, testcase.ProviderConfigKey)
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, actual)
+			}
+		})
+	}
+}
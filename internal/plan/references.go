@@ -0,0 +1,279 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/aztfpreflight/internal/planfile"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// maxReferenceDepth limits how many variables, module outputs and for_each expressions are followed to resolve a reference.
+const maxReferenceDepth = 32
+
+// countIndexKey is the instance key of the references indexed by `count.index`, like `azurerm_subnet.s[count.index].id`.
+const countIndexKey = "[count.index]"
+
+// referenceScope is where an expression is evaluated: the module instance, and the for_each expression and instance key of its block.
+type referenceScope struct {
+	ModuleAddress string
+	ForEach       *tfjson.Expression
+	// Key is the instance key of the block in the reference format, like `["a"]` for for_each or `[0]` for count
+	Key string
+}
+
+// ReferenceResolver resolves the references in the configuration of a module to the addresses of the resources they come from,
+// following the module call arguments, the module outputs and the `each.value` of the for_each expressions.
+// The resolved references are prefixed with the module instance address, like `module.net["eu"].azurerm_subnet.test.id`,
+// so they can be matched with the resource addresses of the plan. The references indexed by `count.index` take the instance key.
+// The locals and the `count.index` indexes are not in the configuration of the JSON plan, they're only resolved for the binary plan files,
+// whose reader replaces the references to locals with the references of their values.
+// The constant value of the resolved expressions which aren't a single reference, through the variables, the module outputs
+// and the for_each expressions, is nil instead of unknown, see planfile.IsSingleReference. It's always nil for the JSON plans.
+type ReferenceResolver struct {
+	root *tfjson.ConfigModule
+}
+
+func NewReferenceResolver(root *tfjson.ConfigModule) *ReferenceResolver {
+	return &ReferenceResolver{root: root}
+}
+
+// ResourceConfig returns the configuration of the resource instance with its references resolved, or nil if it's not found.
+// The returned expression is a copy, the configuration of the plan is not modified.
+func (r *ReferenceResolver) ResourceConfig(moduleAddress string, mode tfjson.ResourceMode, resourceType string, name string, index interface{}) *tfjson.Expression {
+	resource := r.resource(moduleAddress, mode, resourceType, name)
+	if resource == nil {
+		return nil
+	}
+	scope := referenceScope{
+		ModuleAddress: moduleAddress,
+		ForEach:       resource.ForEachExpression,
+		Key:           planfile.FormatIndex(index),
+	}
+	return r.resolveExpression(&tfjson.Expression{
+		ExpressionData: &tfjson.ExpressionData{
+			NestedBlocks: []map[string]*tfjson.Expression{resource.Expressions},
+		},
+	}, scope)
+}
+
+// ProviderConfigKey returns the key of the provider configuration used by the resource in the configuration of the plan, or an empty string if it's not found.
+func (r *ReferenceResolver) ProviderConfigKey(moduleAddress string, mode tfjson.ResourceMode, resourceType string, name string) string {
+	resource := r.resource(moduleAddress, mode, resourceType, name)
+	if resource == nil {
+		return ""
+	}
+	return resource.ProviderConfigKey
+}
+
+func (r *ReferenceResolver) resource(moduleAddress string, mode tfjson.ResourceMode, resourceType string, name string) *tfjson.ConfigResource {
+	module := r.module(moduleAddress)
+	if module == nil {
+		return nil
+	}
+	address := fmt.Sprintf("%s.%s", resourceType, name)
+	if mode == tfjson.DataResourceMode {
+		address = "data." + address
+	}
+	for _, resource := range module.Resources {
+		if resource.Address == address {
+			return resource
+		}
+	}
+	return nil
+}
+
+func (r *ReferenceResolver) resolveExpression(expr *tfjson.Expression, scope referenceScope) *tfjson.Expression {
+	if expr == nil || expr.ExpressionData == nil {
+		return expr
+	}
+	out := &tfjson.Expression{
+		ExpressionData: &tfjson.ExpressionData{
+			ConstantValue: expr.ConstantValue,
+		},
+	}
+	if len(expr.References) > 0 {
+		references, single := r.resolveReferences(expr.References, scope, 0)
+		out.References = references
+		if !single || !planfile.IsSingleReference(expr) {
+			out.ConstantValue = nil
+		}
+	}
+	if expr.NestedBlocks != nil {
+		out.NestedBlocks = make([]map[string]*tfjson.Expression, 0, len(expr.NestedBlocks))
+		for _, block := range expr.NestedBlocks {
+			nestedBlock := make(map[string]*tfjson.Expression, len(block))
+			for key, value := range block {
+				nestedBlock[key] = r.resolveExpression(value, scope)
+			}
+			out.NestedBlocks = append(out.NestedBlocks, nestedBlock)
+		}
+	}
+	return out
+}
+
+// resolveReferences returns the resolved references without duplicates, in the order of the input references.
+// It also reports whether all the variables, module outputs and for_each expressions they go through are single references.
+func (r *ReferenceResolver) resolveReferences(references []string, scope referenceScope, depth int) ([]string, bool) {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	single := true
+	for _, reference := range references {
+		resolvedReferences, ok := r.resolveReference(reference, scope, depth)
+		single = single && ok
+		for _, resolved := range resolvedReferences {
+			if !seen[resolved] {
+				seen[resolved] = true
+				out = append(out, resolved)
+			}
+		}
+	}
+	return out, single
+}
+
+func (r *ReferenceResolver) resolveReference(reference string, scope referenceScope, depth int) ([]string, bool) {
+	if depth > maxReferenceDepth {
+		return nil, false
+	}
+	if strings.Contains(reference, countIndexKey) {
+		if !strings.HasPrefix(scope.Key, "[") || strings.HasPrefix(scope.Key, `["`) {
+			// the block has no count
+			return nil, false
+		}
+		reference = strings.ReplaceAll(reference, countIndexKey, scope.Key)
+	}
+	root, rest := cutReferenceStep(reference)
+	switch root {
+	case "var":
+		name, rest := cutReferenceStep(rest)
+		parentAddress, callName, key := splitModuleAddress(scope.ModuleAddress)
+		if callName == "" {
+			return nil, false
+		}
+		call := r.moduleCall(parentAddress, callName)
+		if call == nil || call.Expressions[name] == nil {
+			return nil, false
+		}
+		parentScope := referenceScope{
+			ModuleAddress: parentAddress,
+			ForEach:       call.ForEachExpression,
+			Key:           key,
+		}
+		references, single := r.resolveReferences(appendReferences(call.Expressions[name].References, rest), parentScope, depth+1)
+		return references, single && planfile.IsSingleReference(call.Expressions[name])
+	case "each":
+		name, rest := cutReferenceStep(rest)
+		if name != "value" || scope.ForEach == nil || scope.Key == "" {
+			return nil, false
+		}
+		// the for_each expression is evaluated in the module, outside the block
+		references, single := r.resolveReferences(appendReferences(appendReferences(scope.ForEach.References, scope.Key), rest), referenceScope{ModuleAddress: scope.ModuleAddress}, depth+1)
+		return references, single && planfile.IsSingleReference(scope.ForEach)
+	case "module":
+		name, rest := cutReferenceStep(rest)
+		key := ""
+		if strings.HasPrefix(rest, "[") {
+			key, rest = cutReferenceStep(rest)
+		}
+		output, rest := cutReferenceStep(rest)
+		call := r.moduleCall(scope.ModuleAddress, name)
+		if call == nil || call.Module == nil || call.Module.Outputs[output] == nil || call.Module.Outputs[output].Expression == nil {
+			return nil, false
+		}
+		if key == "" && (call.CountExpression != nil || call.ForEachExpression != nil) {
+			// the output of all the module instances is referenced
+			return nil, false
+		}
+		childScope := referenceScope{ModuleAddress: joinModuleAddress(scope.ModuleAddress, moduleCallInstance{Name: name, Key: key}.String())}
+		expr := call.Module.Outputs[output].Expression
+		references, single := r.resolveReferences(appendReferences(expr.References, rest), childScope, depth+1)
+		return references, single && planfile.IsSingleReference(expr)
+	case "local", "count", "path", "terraform", "self":
+		return nil, false
+	}
+	return []string{joinModuleAddress(scope.ModuleAddress, reference)}, true
+}
+
+// module returns the configuration of the module instance by walking the module calls from the root module, the instance keys are ignored.
+// It returns nil if the module address is invalid or the module is not found.
+func (r *ReferenceResolver) module(moduleAddress string) *tfjson.ConfigModule {
+	instances, err := parseModuleAddress(moduleAddress)
+	if err != nil {
+		return nil
+	}
+	module := r.root
+	for _, instance := range instances {
+		if module == nil || module.ModuleCalls[instance.Name] == nil {
+			return nil
+		}
+		module = module.ModuleCalls[instance.Name].Module
+	}
+	return module
+}
+
+func (r *ReferenceResolver) moduleCall(moduleAddress string, name string) *tfjson.ModuleCall {
+	module := r.module(moduleAddress)
+	if module == nil {
+		return nil
+	}
+	return module.ModuleCalls[name]
+}
+
+// cutReferenceStep cuts the first step of a reference, which is a name or an instance key like `["a.b"]`, the rest doesn't have the leading dot.
+func cutReferenceStep(reference string) (string, string) {
+	if strings.HasPrefix(reference, "[") {
+		inQuote := false
+		for i := 1; i < len(reference); i++ {
+			switch {
+			case inQuote && reference[i] == '\\':
+				i++
+			case reference[i] == '"':
+				inQuote = !inQuote
+			case !inQuote && reference[i] == ']':
+				return reference[:i+1], strings.TrimPrefix(reference[i+1:], ".")
+			}
+		}
+		return reference, ""
+	}
+	end := strings.IndexAny(reference, ".[")
+	if end < 0 {
+		return reference, ""
+	}
+	if reference[end] == '.' {
+		return reference[:end], reference[end+1:]
+	}
+	return reference[:end], reference[end:]
+}
+
+// splitModuleAddress splits a module instance address into its parent module address, and the name and instance key of its module call.
+// The name is empty for the root module or an invalid address.
+func splitModuleAddress(moduleAddress string) (string, string, string) {
+	instances, err := parseModuleAddress(moduleAddress)
+	if err != nil || len(instances) == 0 {
+		return "", "", ""
+	}
+	last := instances[len(instances)-1]
+	return formatModuleAddress(instances[:len(instances)-1]), last.Name, last.Key
+}
+
+// appendReferences appends the rest of a reference, like `.id` or `["a"].id`, to each of the references.
+func appendReferences(references []string, rest string) []string {
+	if rest == "" {
+		return references
+	}
+	if !strings.HasPrefix(rest, "[") {
+		rest = "." + rest
+	}
+	out := make([]string, 0, len(references))
+	for _, reference := range references {
+		out = append(out, reference+rest)
+	}
+	return out
+}
+
+func joinModuleAddress(moduleAddress string, address string) string {
+	if moduleAddress == "" {
+		return address
+	}
+	return fmt.Sprintf("%s.%s", moduleAddress, address)
+}
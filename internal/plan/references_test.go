@@ -0,0 +1,218 @@
+package plan_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/plan"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_ReferenceResolver(t *testing.T) {
+	reference := func(references ...string) *tfjson.Expression {
+		return &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: references}}
+	}
+	// module "net" is called for each subnet, it creates a network interface in the subnet and outputs its ID
+	netModule := &tfjson.ConfigModule{
+		Resources: []*tfjson.ConfigResource{
+			{
+				Address: "azurerm_network_interface.test",
+				Mode:    tfjson.ManagedResourceMode,
+				Type:    "azurerm_network_interface",
+				Name:    "test",
+				Expressions: map[string]*tfjson.Expression{
+					"name":      reference("local.name"),
+					"subnet_id": reference("var.subnet_id"),
+				},
+			},
+		},
+		Outputs: map[string]*tfjson.ConfigOutput{
+			"nic_id": {Expression: reference("azurerm_network_interface.test.id", "azurerm_network_interface.test")},
+		},
+	}
+	root := &tfjson.ConfigModule{
+		Resources: []*tfjson.ConfigResource{
+			{
+				Address:           "azurerm_subnet.test",
+				Mode:              tfjson.ManagedResourceMode,
+				Type:              "azurerm_subnet",
+				Name:              "test",
+				ForEachExpression: reference("var.subnets"),
+			},
+			{
+				Address:           "azurerm_network_security_group.test",
+				Mode:              tfjson.ManagedResourceMode,
+				Type:              "azurerm_network_security_group",
+				Name:              "test",
+				ForEachExpression: reference("azurerm_subnet.test"),
+				Expressions: map[string]*tfjson.Expression{
+					"name": reference("each.value.name", "each.value"),
+				},
+			},
+			{
+				Address: "azurerm_virtual_machine.test",
+				Mode:    tfjson.ManagedResourceMode,
+				Type:    "azurerm_virtual_machine",
+				Name:    "test",
+				Expressions: map[string]*tfjson.Expression{
+					"network_interface_ids": reference(`module.net["a"].nic_id`, `module.net["a"]`),
+					"vm_size":               reference("count.index"),
+				},
+			},
+			{
+				Address:         "azurerm_lb.test",
+				Mode:            tfjson.ManagedResourceMode,
+				Type:            "azurerm_lb",
+				Name:            "test",
+				CountExpression: reference("var.lb_count"),
+				Expressions: map[string]*tfjson.Expression{
+					"public_ip_address_id": reference("azurerm_public_ip.test[count.index].id", "azurerm_public_ip.test[count.index]", "azurerm_public_ip.test", "count.index"),
+				},
+			},
+		},
+		ModuleCalls: map[string]*tfjson.ModuleCall{
+			"net": {
+				ForEachExpression: reference("azurerm_subnet.test"),
+				Expressions: map[string]*tfjson.Expression{
+					"subnet_id": reference("each.value.id", "each.value"),
+				},
+				Module: netModule,
+			},
+		},
+	}
+	resolver := plan.NewReferenceResolver(root)
+
+	testcases := []struct {
+		Name          string
+		ModuleAddress string
+		ResourceType  string
+		Index         interface{}
+		Expect        map[string][]string
+	}{
+		{
+			Name:          "module variable from each.value of the module call",
+			ModuleAddress: `module.net["a.b"]`,
+			ResourceType:  "azurerm_network_interface",
+			Expect: map[string][]string{
+				"name":      {},
+				"subnet_id": {`azurerm_subnet.test["a.b"].id`, `azurerm_subnet.test["a.b"]`},
+			},
+		},
+		{
+			Name:         "each.value of the resource",
+			ResourceType: "azurerm_network_security_group",
+			Index:        "a",
+			Expect: map[string][]string{
+				"name": {`azurerm_subnet.test["a"].name`, `azurerm_subnet.test["a"]`},
+			},
+		},
+		{
+			Name:         "module output",
+			ResourceType: "azurerm_virtual_machine",
+			Expect: map[string][]string{
+				"network_interface_ids": {`module.net["a"].azurerm_network_interface.test.id`, `module.net["a"].azurerm_network_interface.test`},
+				"vm_size":               {},
+			},
+		},
+		{
+			Name:         "count.index of the resource",
+			ResourceType: "azurerm_lb",
+			Index:        float64(1),
+			Expect: map[string][]string{
+				"public_ip_address_id": {"azurerm_public_ip.test[1].id", "azurerm_public_ip.test[1]", "azurerm_public_ip.test"},
+			},
+		},
+		{
+			Name:         "count.index without an instance key",
+			ResourceType: "azurerm_lb",
+			Expect: map[string][]string{
+				"public_ip_address_id": {"azurerm_public_ip.test"},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			config := resolver.ResourceConfig(testcase.ModuleAddress, tfjson.ManagedResourceMode, testcase.ResourceType, "test", testcase.Index)
+			if config == nil || len(config.NestedBlocks) != 1 {
+				t.Fatalf("Expected the resource config, got %v", config)
+			}
+			actual := make(map[string][]string)
+			for key, expr := range config.NestedBlocks[0] {
+				actual[key] = expr.References
+			}
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, actual)
+			}
+		})
+	}
+
+	if config := resolver.ResourceConfig("", tfjson.ManagedResourceMode, "azurerm_network_interface", "test", nil); config != nil {
+		t.Fatalf("Expected no config for a resource outside of its module, got %v", config)
+	}
+}
+
+func Test_ReferenceResolver_NestedModuleInstances(t *testing.T) {
+	reference := func(references ...string) *tfjson.Expression {
+		return &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{ConstantValue: tfjson.UnknownConstantValue, References: references}}
+	}
+	netModule := &tfjson.ConfigModule{
+		Resources: []*tfjson.ConfigResource{
+			{
+				Address: "azurerm_subnet.s",
+				Mode:    tfjson.ManagedResourceMode,
+				Type:    "azurerm_subnet",
+				Name:    "s",
+				Expressions: map[string]*tfjson.Expression{
+					"virtual_network_name": reference("var.vnet_name"),
+				},
+			},
+		},
+	}
+	spokeModule := &tfjson.ConfigModule{
+		Resources: []*tfjson.ConfigResource{
+			{
+				Address: "azurerm_virtual_network.test",
+				Mode:    tfjson.ManagedResourceMode,
+				Type:    "azurerm_virtual_network",
+				Name:    "test",
+			},
+		},
+		ModuleCalls: map[string]*tfjson.ModuleCall{
+			"net": {
+				CountExpression: &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{ConstantValue: float64(2)}},
+				Expressions: map[string]*tfjson.Expression{
+					"vnet_name": reference("azurerm_virtual_network.test.name", "azurerm_virtual_network.test"),
+				},
+				Module: netModule,
+			},
+		},
+	}
+	root := &tfjson.ConfigModule{
+		ModuleCalls: map[string]*tfjson.ModuleCall{
+			"spoke": {
+				ForEachExpression: reference("var.spokes"),
+				Module:            spokeModule,
+			},
+		},
+	}
+	resolver := plan.NewReferenceResolver(root)
+
+	config := resolver.ResourceConfig(`module.spoke["prod.eu"].module.net[0]`, tfjson.ManagedResourceMode, "azurerm_subnet", "s", nil)
+	if config == nil || len(config.NestedBlocks) != 1 {
+		t.Fatalf("Expected the resource config, got %v", config)
+	}
+	expect := []string{`module.spoke["prod.eu"].azurerm_virtual_network.test.name`, `module.spoke["prod.eu"].azurerm_virtual_network.test`}
+	if actual := config.NestedBlocks[0]["virtual_network_name"].References; !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+	if actual := config.NestedBlocks[0]["virtual_network_name"].ConstantValue; actual != nil {
+		t.Fatalf("Expected no constant value for an expression which isn't known to be a single reference, got %v", actual)
+	}
+
+	for _, moduleAddress := range []string{`module.spoke["prod.eu"].module.vnet[0]`, `module.spoke["prod.eu"`, `module.spoke["prod.eu"].net[0]`} {
+		if config := resolver.ResourceConfig(moduleAddress, tfjson.ManagedResourceMode, "azurerm_subnet", "s", nil); config != nil {
+			t.Fatalf("Expected no config for %s, got %v", moduleAddress, config)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package plan
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// SessionResource returns the synthetic ARM resource of the request, which is returned for the GET requests of its dependents.
+// It's the request body, or the body merged onto the existing resource, with the ID, name and type of the resource. It returns false if the request doesn't write the full body of an ARM resource.
+func SessionResource(model types.RequestModel) (string, bool) {
+	if model.Failed != nil || model.Kind != types.KindARMWrite || model.PreflightSkipReason() != "" {
+		return "", false
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(model.PreflightBody()), &body); err != nil || body == nil {
+		return "", false
+	}
+	return syntheticResource(model.ResourceId, body)
+}
+
+// PriorStateSessionResources returns the synthetic ARM resources of the existing resources and data sources in the prior state, keyed by their resource IDs.
+// They're built from the `location` and `tags` of the prior state, and their properties are empty.
+// Only the resources which aren't changed by the plan are returned: the GET requests of a creation must not find the resource,
+// and an update reads the full body of the resource that seeding its prior state caches, see tfclient.TerraformClient.ApplyResource.
+func PriorStateSessionResources(tfplan *tfjson.Plan) map[string]string {
+	out := make(map[string]string)
+	actions := plannedActions(tfplan)
+	walkPriorState(tfplan, func(resource *tfjson.StateResource) {
+		if action, ok := actions[resource.Address]; ok && !action.NoOp() && !action.Read() {
+			return
+		}
+		resourceId, _ := resource.AttributeValues["id"].(string)
+		body := make(map[string]interface{})
+		if location, ok := resource.AttributeValues["location"].(string); ok && location != "" {
+			body["location"] = location
+		}
+		if tags, ok := resource.AttributeValues["tags"].(map[string]interface{}); ok && len(tags) != 0 {
+			body["tags"] = tags
+		}
+		if value, ok := syntheticResource(resourceId, body); ok {
+			out[resourceId] = value
+		}
+	})
+	return out
+}
+
+// PlaceholderSessionResources returns the synthetic ARM resources of the resources outside the plan, keyed by their resource IDs.
+// They're the resources whose IDs are known values of the planned resources, like a `network_security_group_id` managed in another configuration,
+// and the parents of the planned resources and of those resources, like the virtual network of an updated subnet.
+// They only have their ID, name and type, and their properties are empty.
+// The resources of the plan are skipped, and so are the resources which have the name of a created resource, because the GET requests of a creation must not find the resource.
+func PlaceholderSessionResources(tfplan *tfjson.Plan) map[string]string {
+	planIds := make(map[string]bool)
+	createdNames := make(map[string]bool)
+	candidates := make([]string, 0)
+	for _, change := range tfplan.ResourceChanges {
+		if change.Change == nil {
+			continue
+		}
+		for _, value := range []interface{}{change.Change.Before, change.Change.After} {
+			attributes, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if resourceId, ok := attributes["id"].(string); ok && resourceId != "" {
+				planIds[strings.ToLower(strings.TrimSuffix(resourceId, "/"))] = true
+				candidates = append(candidates, resourceId)
+			}
+		}
+		after, ok := change.Change.After.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if change.Change.Actions.Create() || change.Change.Actions.Replace() {
+			if name, ok := after["name"].(string); ok && name != "" {
+				createdNames[strings.ToLower(name)] = true
+			}
+		}
+		for key, value := range after {
+			if key != "id" {
+				candidates = appendStrings(candidates, value)
+			}
+		}
+	}
+
+	out := make(map[string]string)
+	for _, candidate := range candidates {
+		armId, err := arm.ParseResourceID(candidate)
+		if err != nil {
+			continue
+		}
+		for ; armId != nil && armId.Name != "" && armId.Parent != nil; armId = armId.Parent {
+			if armId.ResourceType.String() == arm.SubscriptionResourceType.String() || armId.ResourceType.String() == arm.TenantResourceType.String() {
+				break
+			}
+			resourceId := armId.String()
+			if planIds[strings.ToLower(resourceId)] || createdNames[strings.ToLower(armId.Name)] {
+				continue
+			}
+			if value, ok := syntheticResource(resourceId, make(map[string]interface{})); ok {
+				out[resourceId] = value
+			}
+		}
+	}
+	return out
+}
+
+// appendStrings appends the string values in the input to the output.
+func appendStrings(out []string, input interface{}) []string {
+	switch v := input.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			out = appendStrings(out, value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			out = appendStrings(out, value)
+		}
+	case string:
+		out = append(out, v)
+	}
+	return out
+}
+
+func syntheticResource(resourceId string, body map[string]interface{}) (string, bool) {
+	armId, err := arm.ParseResourceID(resourceId)
+	if err != nil || armId.Name == "" {
+		return "", false
+	}
+	body["id"] = armId.String()
+	body["name"] = armId.Name
+	body["type"] = armId.ResourceType.String()
+	if _, ok := body["properties"]; !ok {
+		body["properties"] = map[string]interface{}{}
+	}
+	out, err := json.Marshal(body)
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
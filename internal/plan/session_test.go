@@ -0,0 +1,192 @@
+package plan_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/plan"
+	"github.com/Azure/aztfpreflight/internal/types"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_SessionResource(t *testing.T) {
+	vnetUrl := "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01"
+	testcases := []struct {
+		Name   string
+		Model  types.RequestModel
+		Expect map[string]interface{}
+	}{
+		{
+			Name:  "put",
+			Model: types.NewRequestModel("PUT", vnetUrl, `{"location":"westeurope","properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]}}}`, nil),
+			Expect: map[string]interface{}{
+				"id":         "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+				"name":       "vnet",
+				"type":       "Microsoft.Network/virtualNetworks",
+				"location":   "westeurope",
+				"properties": map[string]interface{}{"addressSpace": map[string]interface{}{"addressPrefixes": []interface{}{"10.0.0.0/16"}}},
+			},
+		},
+		{
+			Name:  "patch",
+			Model: types.NewRequestModel("PATCH", vnetUrl, `{"tags":{}}`, nil),
+		},
+		{
+			Name:  "data plane",
+			Model: types.NewRequestModel("PUT", "https://kv.vault.azure.net/secrets/secret?api-version=7.4", `{"value":"secret"}`, nil),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			body, ok := plan.SessionResource(testcase.Model)
+			if ok != (testcase.Expect != nil) {
+				t.Fatalf("Expected a session resource: %v, got %q", testcase.Expect != nil, body)
+			}
+			if !ok {
+				return
+			}
+			var actual map[string]interface{}
+			if err := json.Unmarshal([]byte(body), &actual); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, actual)
+			}
+		})
+	}
+}
+
+func Test_PriorStateSessionResources(t *testing.T) {
+	vnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	subnetId := vnetId + "/subnets/subnet"
+	tfplan := &tfjson.Plan{
+		PriorState: &tfjson.State{
+			Values: &tfjson.StateValues{
+				RootModule: &tfjson.StateModule{
+					Resources: []*tfjson.StateResource{
+						{
+							Address:         "azurerm_virtual_network.test",
+							Mode:            tfjson.ManagedResourceMode,
+							AttributeValues: map[string]interface{}{"id": vnetId, "location": "westeurope", "tags": map[string]interface{}{"env": "prod"}},
+						},
+						{
+							Address:         "azurerm_subnet.test",
+							Mode:            tfjson.ManagedResourceMode,
+							AttributeValues: map[string]interface{}{"id": subnetId},
+						},
+						{
+							Address:         "azurerm_resource_group.test",
+							Mode:            tfjson.ManagedResourceMode,
+							AttributeValues: map[string]interface{}{"id": "/subscriptions/000/resourceGroups/rg", "location": "westeurope"},
+						},
+					},
+				},
+			},
+		},
+		ResourceChanges: []*tfjson.ResourceChange{
+			{
+				Address: "azurerm_resource_group.test",
+				Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionNoop}},
+			},
+			{
+				Address: "azurerm_virtual_network.test",
+				Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionUpdate}},
+			},
+			{
+				Address: "azurerm_subnet.test",
+				Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionDelete, tfjson.ActionCreate}},
+			},
+		},
+	}
+
+	actual := plan.PriorStateSessionResources(tfplan)
+	expect := map[string]map[string]interface{}{
+		"/subscriptions/000/resourceGroups/rg": {
+			"id":         "/subscriptions/000/resourceGroups/rg",
+			"name":       "rg",
+			"type":       "Microsoft.Resources/resourceGroups",
+			"location":   "westeurope",
+			"properties": map[string]interface{}{},
+		},
+	}
+	if len(actual) != len(expect) {
+		t.Fatalf("Expected %d resources, got %v", len(expect), actual)
+	}
+	for resourceId, body := range actual {
+		var value map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &value); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(value, expect[resourceId]) {
+			t.Fatalf("Expected %v, got %v", expect[resourceId], value)
+		}
+	}
+}
+
+func Test_PlaceholderSessionResources(t *testing.T) {
+	vnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	subnetId := vnetId + "/subnets/subnet"
+	nsgId := "/subscriptions/000/resourceGroups/shared/providers/Microsoft.Network/networkSecurityGroups/nsg"
+	tfplan := &tfjson.Plan{
+		ResourceChanges: []*tfjson.ResourceChange{
+			{
+				Address: "azurerm_subnet.test",
+				Change: &tfjson.Change{
+					Actions: tfjson.Actions{tfjson.ActionUpdate},
+					Before:  map[string]interface{}{"id": subnetId, "name": "subnet"},
+					After:   map[string]interface{}{"id": subnetId, "name": "subnet"},
+				},
+			},
+			{
+				Address: "azurerm_subnet_network_security_group_association.test",
+				Change: &tfjson.Change{
+					Actions: tfjson.Actions{tfjson.ActionCreate},
+					After:   map[string]interface{}{"subnet_id": subnetId, "network_security_group_id": nsgId},
+				},
+			},
+			{
+				Address: "azurerm_resource_group.test",
+				Change: &tfjson.Change{
+					Actions: tfjson.Actions{tfjson.ActionCreate},
+					After:   map[string]interface{}{"name": "shared", "location": "westeurope"},
+				},
+			},
+		},
+	}
+
+	actual := plan.PlaceholderSessionResources(tfplan)
+	expect := map[string]map[string]interface{}{
+		vnetId: {
+			"id":         vnetId,
+			"name":       "vnet",
+			"type":       "Microsoft.Network/virtualNetworks",
+			"properties": map[string]interface{}{},
+		},
+		"/subscriptions/000/resourceGroups/rg": {
+			"id":         "/subscriptions/000/resourceGroups/rg",
+			"name":       "rg",
+			"type":       "Microsoft.Resources/resourceGroups",
+			"properties": map[string]interface{}{},
+		},
+		nsgId: {
+			"id":         nsgId,
+			"name":       "nsg",
+			"type":       "Microsoft.Network/networkSecurityGroups",
+			"properties": map[string]interface{}{},
+		},
+	}
+	if len(actual) != len(expect) {
+		t.Fatalf("Expected %d resources, got %v", len(expect), actual)
+	}
+	for resourceId, body := range actual {
+		var value map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &value); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(value, expect[resourceId]) {
+			t.Fatalf("Expected %v, got %v", expect[resourceId], value)
+		}
+	}
+}
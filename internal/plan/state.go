@@ -0,0 +1,51 @@
+package plan
+
+import (
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// PriorStateValues returns the known values of the existing resources and data sources in the prior state.
+// The keys are the references resolved by the ReferenceResolver, like `data.azurerm_subnet.test.id` or `module.kv[0].azurerm_key_vault.test[0].id`.
+// Only the string, number and bool attributes are returned, and the resources which are changed by the plan are skipped, because their values
+// are not known until apply.
+func PriorStateValues(tfplan *tfjson.Plan) map[string]interface{} {
+	out := make(map[string]interface{})
+	actions := plannedActions(tfplan)
+	walkPriorState(tfplan, func(resource *tfjson.StateResource) {
+		if action, ok := actions[resource.Address]; ok && !action.NoOp() {
+			return
+		}
+		flattenKnownValues(out, resource.Address, resource.AttributeValues)
+	})
+	return out
+}
+
+// plannedActions returns the planned actions of the resources, keyed by their addresses.
+func plannedActions(tfplan *tfjson.Plan) map[string]tfjson.Actions {
+	out := make(map[string]tfjson.Actions)
+	for _, change := range tfplan.ResourceChanges {
+		if change.Change != nil {
+			out[change.Address] = change.Change.Actions
+		}
+	}
+	return out
+}
+
+// walkPriorState calls the function for every resource and data source in the prior state, the deposed instances are skipped.
+func walkPriorState(tfplan *tfjson.Plan, fn func(resource *tfjson.StateResource)) {
+	if tfplan == nil || tfplan.PriorState == nil || tfplan.PriorState.Values == nil || tfplan.PriorState.Values.RootModule == nil {
+		return
+	}
+	modules := []*tfjson.StateModule{tfplan.PriorState.Values.RootModule}
+	for len(modules) > 0 {
+		module := modules[0]
+		modules = append(modules[1:], module.ChildModules...)
+
+		for _, resource := range module.Resources {
+			if resource.DeposedKey != "" {
+				continue
+			}
+			fn(resource)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package plan_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/plan"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_PriorStateValues(t *testing.T) {
+	subnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	keyVaultId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv"
+	tfplan := &tfjson.Plan{
+		PriorState: &tfjson.State{
+			Values: &tfjson.StateValues{
+				RootModule: &tfjson.StateModule{
+					Resources: []*tfjson.StateResource{
+						{
+							Address:         "data.azurerm_subnet.test",
+							Mode:            tfjson.DataResourceMode,
+							AttributeValues: map[string]interface{}{"id": subnetId, "name": "subnet", "address_prefixes": []interface{}{"10.0.0.0/24"}, "enforce_private_link_endpoint_network_policies": false},
+						},
+						{
+							Address:         "azurerm_resource_group.test",
+							Mode:            tfjson.ManagedResourceMode,
+							AttributeValues: map[string]interface{}{"id": "/subscriptions/000/resourceGroups/old"},
+						},
+					},
+					ChildModules: []*tfjson.StateModule{
+						{
+							Address: "module.kv[0]",
+							Resources: []*tfjson.StateResource{
+								{
+									Address:         "module.kv[0].azurerm_key_vault.test[0]",
+									Mode:            tfjson.ManagedResourceMode,
+									AttributeValues: map[string]interface{}{"id": keyVaultId},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		ResourceChanges: []*tfjson.ResourceChange{
+			{
+				Address: "azurerm_resource_group.test",
+				Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionDelete, tfjson.ActionCreate}},
+			},
+			{
+				Address: "module.kv[0].azurerm_key_vault.test[0]",
+				Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionNoop}},
+			},
+		},
+	}
+
+	expect := map[string]interface{}{
+		"data.azurerm_subnet.test.enforce_private_link_endpoint_network_policies": false,
+		"data.azurerm_subnet.test.id":                  subnetId,
+		"data.azurerm_subnet.test.name":                "subnet",
+		"data.azurerm_subnet.test.address_prefixes[0]": "10.0.0.0/24",
+		"module.kv[0].azurerm_key_vault.test[0].id":    keyVaultId,
+	}
+	if actual := plan.PriorStateValues(tfplan); !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+}
+
+func Test_UpdateConfigWithPriorStateValues(t *testing.T) {
+	subnetId := "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	config := &tfjson.Expression{
+		ExpressionData: &tfjson.ExpressionData{
+			NestedBlocks: []map[string]*tfjson.Expression{
+				{
+					"subnet_id": {ExpressionData: &tfjson.ExpressionData{
+						ConstantValue: tfjson.UnknownConstantValue,
+						References:    []string{"data.azurerm_subnet.test.id", "data.azurerm_subnet.test"},
+					}},
+				},
+			},
+		},
+	}
+
+	config = plan.UpdateConfigWithKnownValues(config, map[string]interface{}{"data.azurerm_subnet.test.id": subnetId}, nil)
+	actual := plan.PlannedValue(map[string]interface{}{}, map[string]interface{}{"subnet_id": true}, config, nil, "azurerm_network_interface")
+	expect := map[string]interface{}{"subnet_id": subnetId}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+}
+
+func Test_UpdateConfigWithKnownValues_References(t *testing.T) {
+	knownValues := map[string]interface{}{"azurerm_resource_group.rg.name": "rg", "azurerm_public_ip.ip.idle_timeout_in_minutes": float64(4)}
+	testcases := []struct {
+		Name          string
+		ConstantValue interface{}
+		References    []string
+		Expect        interface{}
+	}{
+		{
+			Name:          "single reference",
+			ConstantValue: tfjson.UnknownConstantValue,
+			References:    []string{"azurerm_resource_group.rg.name", "azurerm_resource_group.rg"},
+			Expect:        "rg",
+		},
+		{
+			Name:          "number",
+			ConstantValue: tfjson.UnknownConstantValue,
+			References:    []string{"azurerm_public_ip.ip.idle_timeout_in_minutes", "azurerm_public_ip.ip"},
+			Expect:        float64(4),
+		},
+		{
+			// like `"${azurerm_resource_group.rg.name}-vnet"`, the resolver sets the constant value of the templates to nil
+			Name:          "template",
+			ConstantValue: nil,
+			References:    []string{"azurerm_resource_group.rg.name", "azurerm_resource_group.rg"},
+			Expect:        nil,
+		},
+		{
+			Name:          "composite expression",
+			ConstantValue: tfjson.UnknownConstantValue,
+			References:    []string{"var.prefix", "azurerm_resource_group.rg.name", "azurerm_resource_group.rg"},
+			Expect:        tfjson.UnknownConstantValue,
+		},
+		{
+			Name:          "references with a shared prefix",
+			ConstantValue: tfjson.UnknownConstantValue,
+			References:    []string{"azurerm_resource_group.rg.name", "azurerm_resource_group.rg_name"},
+			Expect:        tfjson.UnknownConstantValue,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			config := &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{
+				ConstantValue: testcase.ConstantValue,
+				References:    testcase.References,
+			}}
+			config = plan.UpdateConfigWithKnownValues(config, knownValues, nil)
+			if config.ConstantValue != testcase.Expect {
+				t.Fatalf("Expected %v, got %v", testcase.Expect, config.ConstantValue)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package planfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// resourceInstanceAddress is a parsed resource instance address, like `module.net["eu"].azurerm_subnet.s[0]`.
+type resourceInstanceAddress struct {
+	ModuleAddress string
+	Mode          tfjson.ResourceMode
+	Type          string
+	Name          string
+	Index         interface{}
+}
+
+// String returns the address without the module address, which is how resources are addressed inside a module.
+func (a resourceInstanceAddress) String() string {
+	out := fmt.Sprintf("%s.%s", a.Type, a.Name)
+	if a.Mode == tfjson.DataResourceMode {
+		out = "data." + out
+	}
+	return out + FormatIndex(a.Index)
+}
+
+// parseResourceInstanceAddress parses the address of a resource instance. Instance keys may contain dots and escaped quotes.
+func parseResourceInstanceAddress(input string) (*resourceInstanceAddress, error) {
+	steps, err := SplitAddress(input)
+	if err != nil {
+		return nil, err
+	}
+
+	moduleSteps := make([]string, 0)
+	for len(steps) >= 2 && steps[0].Name == "module" && steps[0].Index == nil {
+		moduleSteps = append(moduleSteps, "module."+steps[1].Name+FormatIndex(steps[1].Index))
+		steps = steps[2:]
+	}
+
+	out := resourceInstanceAddress{
+		ModuleAddress: strings.Join(moduleSteps, "."),
+		Mode:          tfjson.ManagedResourceMode,
+	}
+	if len(steps) == 3 && steps[0].Name == "data" && steps[0].Index == nil {
+		out.Mode = tfjson.DataResourceMode
+		steps = steps[1:]
+	}
+	if len(steps) != 2 || steps[0].Index != nil {
+		return nil, fmt.Errorf("invalid resource address %q", input)
+	}
+	out.Type = steps[0].Name
+	out.Name = steps[1].Name
+	out.Index = steps[1].Index
+	return &out, nil
+}
+
+// AddressStep is a dot separated name of an address, with its optional instance key.
+type AddressStep struct {
+	Name  string
+	Index interface{}
+}
+
+// SplitAddress splits an address into its dot separated names and their optional instance keys.
+// String keys are returned as string and number keys as float64, matching the JSON plan output.
+func SplitAddress(input string) ([]AddressStep, error) {
+	steps := make([]AddressStep, 0)
+	i := 0
+	for i < len(input) {
+		start := i
+		for i < len(input) && input[i] != '.' && input[i] != '[' {
+			i++
+		}
+		if start == i {
+			return nil, fmt.Errorf("invalid address %q: empty name at position %d", input, i)
+		}
+		step := AddressStep{Name: input[start:i]}
+
+		if i < len(input) && input[i] == '[' {
+			i++
+			if i < len(input) && input[i] == '"' {
+				end := i + 1
+				for end < len(input) && input[end] != '"' {
+					if input[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(input) {
+					return nil, fmt.Errorf("invalid address %q: unterminated instance key", input)
+				}
+				key, err := unquoteString(input[i : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid address %q: %w", input, err)
+				}
+				step.Index = key
+				i = end + 1
+			} else {
+				end := strings.IndexByte(input[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid address %q: unterminated instance key", input)
+				}
+				key, err := strconv.Atoi(input[i : i+end])
+				if err != nil {
+					return nil, fmt.Errorf("invalid address %q: %w", input, err)
+				}
+				step.Index = float64(key)
+				i += end
+			}
+			if i >= len(input) || input[i] != ']' {
+				return nil, fmt.Errorf("invalid address %q: unterminated instance key", input)
+			}
+			i++
+		}
+		steps = append(steps, step)
+
+		if i < len(input) {
+			if input[i] != '.' {
+				return nil, fmt.Errorf("invalid address %q: unexpected %q at position %d", input, input[i], i)
+			}
+			i++
+			if i == len(input) {
+				return nil, fmt.Errorf("invalid address %q: trailing dot", input)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// FormatIndex formats the instance key of a resource or module in the JSON plan as in its address, like `["a"]` or `[0]`.
+// It returns an empty string if there's no instance key.
+func FormatIndex(index interface{}) string {
+	switch v := index.(type) {
+	case string:
+		return "[" + quoteString(v) + "]"
+	case float64:
+		return fmt.Sprintf("[%d]", int(v))
+	case int:
+		return fmt.Sprintf("[%d]", v)
+	}
+	return ""
+}
+
+// quoteString quotes a string like Terraform does for the instance keys of the addresses and the references,
+// the template sequences are escaped and only the non-printable characters are escaped as unicode.
+func quoteString(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i, r := range s {
+		switch r {
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '$', '%':
+			out.WriteRune(r)
+			if strings.HasPrefix(s[i+1:], "{") {
+				// double the template introducer to escape it
+				out.WriteRune(r)
+			}
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				out.WriteRune(r)
+			case r < 0x10000:
+				out.WriteString(fmt.Sprintf(`\u%04x`, r))
+			default:
+				out.WriteString(fmt.Sprintf(`\U%08x`, r))
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+// unquoteString unquotes a string quoted by quoteString.
+func unquoteString(s string) (string, error) {
+	out, err := strconv.Unquote(s)
+	if err != nil {
+		return "", err
+	}
+	out = strings.ReplaceAll(out, "$${", "${")
+	return strings.ReplaceAll(out, "%%{", "%{"), nil
+}
+
+// providerFullName returns the provider source address of a provider config address,
+// like `module.net.provider["registry.terraform.io/hashicorp/azurerm"].alias`.
+func providerFullName(input string) string {
+	start := strings.Index(input, `provider["`)
+	if start < 0 {
+		return input
+	}
+	start += len(`provider["`)
+	end := strings.Index(input[start:], `"]`)
+	if end < 0 {
+		return input
+	}
+	return input[start : start+end]
+}
@@ -0,0 +1,177 @@
+package planfile
+
+import (
+	"reflect"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_parseResourceInstanceAddress(t *testing.T) {
+	testcases := []struct {
+		Input     string
+		Expect    *resourceInstanceAddress
+		ExpectErr bool
+	}{
+		{
+			Input: "azurerm_resource_group.test",
+			Expect: &resourceInstanceAddress{
+				Mode: tfjson.ManagedResourceMode,
+				Type: "azurerm_resource_group",
+				Name: "test",
+			},
+		},
+		{
+			Input: "data.azurerm_client_config.current",
+			Expect: &resourceInstanceAddress{
+				Mode: tfjson.DataResourceMode,
+				Type: "azurerm_client_config",
+				Name: "current",
+			},
+		},
+		{
+			Input: `module.spoke["prod.eu"].module.net[0].azurerm_subnet.s["a.b"]`,
+			Expect: &resourceInstanceAddress{
+				ModuleAddress: `module.spoke["prod.eu"].module.net[0]`,
+				Mode:          tfjson.ManagedResourceMode,
+				Type:          "azurerm_subnet",
+				Name:          "s",
+				Index:         "a.b",
+			},
+		},
+		{
+			Input: `module.data.data.azurerm_subnet.s[1]`,
+			Expect: &resourceInstanceAddress{
+				ModuleAddress: "module.data",
+				Mode:          tfjson.DataResourceMode,
+				Type:          "azurerm_subnet",
+				Name:          "s",
+				Index:         float64(1),
+			},
+		},
+		{
+			Input: `azurerm_subnet.s["quote\"]"]`,
+			Expect: &resourceInstanceAddress{
+				Mode:  tfjson.ManagedResourceMode,
+				Type:  "azurerm_subnet",
+				Name:  "s",
+				Index: `quote"]`,
+			},
+		},
+		{
+			Input: `azurerm_subnet.s["zürich $${x} %%{y}\t\u0007"]`,
+			Expect: &resourceInstanceAddress{
+				Mode:  tfjson.ManagedResourceMode,
+				Type:  "azurerm_subnet",
+				Name:  "s",
+				Index: "zürich ${x} %{y}\t\a",
+			},
+		},
+		{
+			Input:     "azurerm_subnet",
+			ExpectErr: true,
+		},
+		{
+			Input:     `azurerm_subnet.s["a`,
+			ExpectErr: true,
+		},
+		{
+			Input:     "azurerm_subnet.s.",
+			ExpectErr: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual, err := parseResourceInstanceAddress(testcase.Input)
+		if testcase.ExpectErr {
+			if err == nil {
+				t.Fatalf("Expected error for input %q, got nil", testcase.Input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error for input %q: %v", testcase.Input, err)
+		}
+		if !reflect.DeepEqual(actual, testcase.Expect) {
+			t.Fatalf("Expected %+v for input %q, got %+v", testcase.Expect, testcase.Input, actual)
+		}
+		if joinAddress(actual.ModuleAddress, actual.String()) != testcase.Input {
+			t.Fatalf("Expected the address %q to be formatted back, got %q", testcase.Input, joinAddress(actual.ModuleAddress, actual.String()))
+		}
+	}
+}
+
+func Test_FormatIndex(t *testing.T) {
+	testcases := []struct {
+		Input  interface{}
+		Expect string
+	}{
+		{
+			Input:  nil,
+			Expect: "",
+		},
+		{
+			Input:  float64(2),
+			Expect: "[2]",
+		},
+		{
+			Input:  "ü",
+			Expect: `["ü"]`,
+		},
+		{
+			Input:  "a\"b\\c\n",
+			Expect: `["a\"b\\c\n"]`,
+		},
+		{
+			Input:  "${a} %{b} $c",
+			Expect: `["$${a} %%{b} $c"]`,
+		},
+		{
+			Input:  "$${a}",
+			Expect: `["$$${a}"]`,
+		},
+		{
+			Input:  "\u200b\U000e0001",
+			Expect: `["\u200b\U000e0001"]`,
+		},
+	}
+
+	for _, testcase := range testcases {
+		actual := FormatIndex(testcase.Input)
+		if actual != testcase.Expect {
+			t.Fatalf("Expected %s for input %q, got %s", testcase.Expect, testcase.Input, actual)
+		}
+		if actual == "" {
+			continue
+		}
+		steps, err := SplitAddress("s" + actual)
+		if err != nil {
+			t.Fatalf("Unexpected error for input %q: %v", testcase.Input, err)
+		}
+		if len(steps) != 1 || steps[0].Index != testcase.Input {
+			t.Fatalf("Expected the index %q to be parsed back, got %+v", testcase.Input, steps)
+		}
+	}
+}
+
+func Test_providerFullName(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect string
+	}{
+		{
+			Input:  `provider["registry.terraform.io/hashicorp/azurerm"]`,
+			Expect: "registry.terraform.io/hashicorp/azurerm",
+		},
+		{
+			Input:  `module.net.provider["registry.opentofu.org/hashicorp/azurerm"].alt`,
+			Expect: "registry.opentofu.org/hashicorp/azurerm",
+		},
+	}
+
+	for _, testcase := range testcases {
+		if actual := providerFullName(testcase.Input); actual != testcase.Expect {
+			t.Fatalf("Expected %q for input %q, got %q", testcase.Expect, testcase.Input, actual)
+		}
+	}
+}
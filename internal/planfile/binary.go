@@ -0,0 +1,146 @@
+package planfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+const (
+	tfplanFilename  = "tfplan"
+	tfstateFilename = "tfstate"
+
+	// planFormatVersion is the JSON plan format version that the binary plan file is converted to
+	planFormatVersion = "1.2"
+)
+
+// IsBinary reports whether the data looks like a binary plan file, which is a zip archive.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+// ReadBinary converts the binary plan file to the same plan as `terraform show -json`, without terraform,
+// the working directory or the providers. Only the parts of the plan used to generate the request payloads are converted:
+// the resource changes, the variables, the prior state and the configuration.
+func ReadBinary(data []byte) (*tfjson.Plan, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("opening plan file: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		if file.Name != tfplanFilename && file.Name != tfstateFilename && !strings.HasPrefix(file.Name, configSnapshotPrefix) {
+			continue
+		}
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from plan file: %w", file.Name, err)
+		}
+		files[file.Name] = content
+	}
+
+	planData, ok := files[tfplanFilename]
+	if !ok {
+		return nil, fmt.Errorf("plan file has no %s", tfplanFilename)
+	}
+	rawPlan, err := decodeTfplan(planData)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", tfplanFilename, err)
+	}
+
+	out := &tfjson.Plan{
+		FormatVersion:    planFormatVersion,
+		TerraformVersion: rawPlan.TerraformVersion,
+		Variables:        make(map[string]*tfjson.PlanVariable),
+		ResourceChanges:  make([]*tfjson.ResourceChange, 0, len(rawPlan.ResourceChanges)),
+	}
+	for name, value := range rawPlan.Variables {
+		decoded, err := decodeMsgpack(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding variable %s: %w", name, err)
+		}
+		decoded, _ = splitUnknowns(decoded)
+		out.Variables[name] = &tfjson.PlanVariable{Value: decoded}
+	}
+	for _, rawChange := range rawPlan.ResourceChanges {
+		change, err := convertResourceChange(rawChange)
+		if err != nil {
+			return nil, fmt.Errorf("converting resource change %s: %w", rawChange.Addr, err)
+		}
+		out.ResourceChanges = append(out.ResourceChanges, change)
+	}
+
+	if out.PriorState, err = decodeState(files[tfstateFilename]); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", tfstateFilename, err)
+	}
+	if out.Config, err = decodeConfig(files); err != nil {
+		return nil, fmt.Errorf("decoding configuration: %w", err)
+	}
+
+	// like `terraform show`, the root module variables which aren't set in the plan use their default values
+	for name, variable := range out.Config.RootModule.Variables {
+		if _, ok := out.Variables[name]; !ok {
+			out.Variables[name] = &tfjson.PlanVariable{Value: variable.Default}
+		}
+	}
+	return out, nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	return io.ReadAll(reader)
+}
+
+func convertResourceChange(input tfplanResourceChange) (*tfjson.ResourceChange, error) {
+	addr, err := parseResourceInstanceAddress(input.Addr)
+	if err != nil {
+		return nil, err
+	}
+	actions, beforeData, afterData, err := input.actions()
+	if err != nil {
+		return nil, err
+	}
+
+	change := &tfjson.Change{
+		Actions: actions,
+	}
+	if beforeData != nil {
+		before, err := decodeMsgpack(beforeData)
+		if err != nil {
+			return nil, fmt.Errorf("decoding before value: %w", err)
+		}
+		change.Before, _ = splitUnknowns(before)
+	}
+	if afterData != nil {
+		after, err := decodeMsgpack(afterData)
+		if err != nil {
+			return nil, fmt.Errorf("decoding after value: %w", err)
+		}
+		change.After, change.AfterUnknown = splitUnknowns(after)
+	}
+
+	out := &tfjson.ResourceChange{
+		Address:       input.Addr,
+		ModuleAddress: addr.ModuleAddress,
+		Mode:          addr.Mode,
+		Type:          addr.Type,
+		Name:          addr.Name,
+		Index:         addr.Index,
+		ProviderName:  providerFullName(input.Provider),
+		DeposedKey:    input.DeposedKey,
+		Change:        change,
+	}
+	if input.PrevRunAddr != "" && input.PrevRunAddr != input.Addr {
+		out.PreviousAddress = input.PrevRunAddr
+	}
+	return out, nil
+}
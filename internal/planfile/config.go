@@ -0,0 +1,759 @@
+package planfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+const (
+	configSnapshotPrefix   = "tfconfig/"
+	configSnapshotManifest = "tfconfig/modules.json"
+	configSnapshotModule   = "tfconfig/m-"
+
+	defaultRegistryHost = "registry.terraform.io"
+
+	// maxLocalDepth limits how many locals are followed to resolve a reference to a local
+	maxLocalDepth = 32
+)
+
+// moduleManifestEntry is an entry of the module manifest in the configuration snapshot.
+// The key is the module call path without instance keys, like `network.subnet`, the root module's key is empty.
+type moduleManifestEntry struct {
+	Key    string `json:"Key"`
+	Source string `json:"Source"`
+}
+
+// configModule is a module of the configuration snapshot, with the information needed to link it into the module tree.
+type configModule struct {
+	Address string
+	Module  *tfjson.ConfigModule
+	// Providers is the set of provider config keys declared in the module, like `azurerm` or `azurerm.alias`
+	Providers map[string]bool
+	// ProviderConfigs is the declared provider configs, keyed by their provider config key in the JSON plan
+	ProviderConfigs map[string]*tfjson.ProviderConfig
+	// ProviderSources is the provider source addresses, keyed by the provider local name
+	ProviderSources map[string]string
+	// ModuleCallProviders is the `providers` argument of the module calls, keyed by the module call name,
+	// it maps the provider config keys in the child module to the provider config keys in the module, like `azurerm` to `azurerm.west`
+	ModuleCallProviders map[string]map[string]string
+}
+
+// decodeConfig converts the configuration snapshot in the plan file to the configuration in the JSON plan.
+// The snapshot contains the source files of each module, keyed by their path in the plan file.
+func decodeConfig(files map[string][]byte) (*tfjson.Config, error) {
+	manifestData, ok := files[configSnapshotManifest]
+	if !ok {
+		return nil, fmt.Errorf("configuration snapshot has no module manifest")
+	}
+	var manifest []moduleManifestEntry
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing module manifest: %w", err)
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		return manifest[i].Key < manifest[j].Key
+	})
+
+	modules := make(map[string]*configModule)
+	for _, entry := range manifest {
+		address := ""
+		if entry.Key != "" {
+			address = "module." + strings.ReplaceAll(entry.Key, ".", ".module.")
+		}
+		module, err := decodeConfigModule(address, moduleFiles(files, entry.Key))
+		if err != nil {
+			return nil, fmt.Errorf("decoding module %q: %w", address, err)
+		}
+		modules[entry.Key] = module
+
+		if entry.Key == "" {
+			continue
+		}
+		parentKey, name := "", entry.Key
+		if index := strings.LastIndex(entry.Key, "."); index >= 0 {
+			parentKey, name = entry.Key[:index], entry.Key[index+1:]
+		}
+		parent, ok := modules[parentKey]
+		if !ok {
+			return nil, fmt.Errorf("module %q has no parent module in the manifest", entry.Key)
+		}
+		if call, ok := parent.Module.ModuleCalls[name]; ok {
+			call.Module = module.Module
+		}
+	}
+
+	root, ok := modules[""]
+	if !ok {
+		return nil, fmt.Errorf("module manifest has no root module")
+	}
+
+	out := &tfjson.Config{
+		ProviderConfigs: make(map[string]*tfjson.ProviderConfig),
+		RootModule:      root.Module,
+	}
+	for key, module := range modules {
+		for configKey, providerConfig := range module.ProviderConfigs {
+			out.ProviderConfigs[configKey] = providerConfig
+		}
+		for _, resource := range module.Module.Resources {
+			resource.ProviderConfigKey = resolveProviderConfigKey(modules, key, resource.ProviderConfigKey)
+			if _, ok := out.ProviderConfigs[resource.ProviderConfigKey]; ok {
+				continue
+			}
+			// the provider isn't configured, an empty config of the root module is used
+			name := strings.Split(resource.ProviderConfigKey, ".")[0]
+			out.ProviderConfigs[resource.ProviderConfigKey] = &tfjson.ProviderConfig{
+				Name:     name,
+				FullName: providerSource(module.ProviderSources, name),
+			}
+		}
+	}
+	return out, nil
+}
+
+// moduleFiles returns the `.tf` files of the module in the configuration snapshot.
+func moduleFiles(files map[string][]byte, key string) map[string][]byte {
+	prefix := configSnapshotModule + key + "/"
+	out := make(map[string][]byte)
+	for name, data := range files {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".tf"):
+			out[name] = data
+		case strings.HasSuffix(name, ".tf.json"):
+			logrus.Warnf("skipping configuration file %s, JSON configuration files are not supported\n", name)
+		}
+	}
+	return out
+}
+
+// resolveProviderConfigKey returns the provider config key in the JSON plan of a provider used in the module.
+// The provider config is looked up in the module and then its parent modules, following the `providers` argument of the module calls,
+// like how terraform passes the providers to child modules.
+func resolveProviderConfigKey(modules map[string]*configModule, moduleKey string, provider string) string {
+	for {
+		module, ok := modules[moduleKey]
+		if ok && module.Providers[provider] {
+			return providerConfigKey(module.Address, provider)
+		}
+		if moduleKey == "" {
+			return provider
+		}
+		parentKey, name := "", moduleKey
+		if index := strings.LastIndex(moduleKey, "."); index >= 0 {
+			parentKey, name = moduleKey[:index], moduleKey[index+1:]
+		}
+		if parent, ok := modules[parentKey]; ok {
+			if mapped, ok := parent.ModuleCallProviders[name][provider]; ok {
+				provider = mapped
+			}
+		}
+		moduleKey = parentKey
+	}
+}
+
+func providerConfigKey(moduleAddress string, provider string) string {
+	if moduleAddress == "" {
+		return provider
+	}
+	return fmt.Sprintf("%s:%s", moduleAddress, provider)
+}
+
+func providerSource(sources map[string]string, name string) string {
+	if source, ok := sources[name]; ok {
+		return source
+	}
+	return fmt.Sprintf("%s/hashicorp/%s", defaultRegistryHost, name)
+}
+
+// decodeConfigModule decodes the configuration files of a module.
+func decodeConfigModule(address string, files map[string][]byte) (*configModule, error) {
+	out := &configModule{
+		Address: address,
+		Module: &tfjson.ConfigModule{
+			Outputs:     make(map[string]*tfjson.ConfigOutput),
+			ModuleCalls: make(map[string]*tfjson.ModuleCall),
+			Variables:   make(map[string]*tfjson.ConfigVariable),
+		},
+		Providers:           make(map[string]bool),
+		ProviderConfigs:     make(map[string]*tfjson.ProviderConfig),
+		ProviderSources:     make(map[string]string),
+		ModuleCallProviders: make(map[string]map[string]string),
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	locals := make(map[string]*tfjson.Expression)
+	bodies := make([]*hclsyntax.Body, 0, len(names))
+	for _, name := range names {
+		file, diags := hclsyntax.ParseConfig(files[name], strings.TrimPrefix(name, configSnapshotPrefix), hcl.InitialPos)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("parsing %s: %s", name, diags.Error())
+		}
+		body, ok := file.Body.(*hclsyntax.Body)
+		if !ok {
+			return nil, fmt.Errorf("parsing %s: unexpected body type %T", name, file.Body)
+		}
+		bodies = append(bodies, body)
+	}
+
+	// provider sources are needed before the provider blocks are decoded
+	for _, body := range bodies {
+		for _, block := range body.Blocks {
+			if block.Type != "terraform" {
+				continue
+			}
+			for _, nested := range block.Body.Blocks {
+				if nested.Type != "required_providers" {
+					continue
+				}
+				for name, attr := range nested.Body.Attributes {
+					if source := requiredProviderSource(attr.Expr); source != "" {
+						out.ProviderSources[name] = source
+					}
+				}
+			}
+		}
+	}
+
+	for _, body := range bodies {
+		for _, block := range body.Blocks {
+			switch block.Type {
+			case "resource", "data":
+				if len(block.Labels) != 2 {
+					continue
+				}
+				out.Module.Resources = append(out.Module.Resources, decodeConfigResource(block))
+			case "module":
+				if len(block.Labels) != 1 {
+					continue
+				}
+				out.Module.ModuleCalls[block.Labels[0]] = decodeModuleCall(block)
+				if providers := moduleCallProviders(block.Body.Attributes["providers"]); len(providers) > 0 {
+					out.ModuleCallProviders[block.Labels[0]] = providers
+				}
+			case "variable":
+				if len(block.Labels) != 1 {
+					continue
+				}
+				variable := &tfjson.ConfigVariable{}
+				if attr, ok := block.Body.Attributes["default"]; ok {
+					variable.Default = decodeExpression(attr.Expr).ConstantValue
+				}
+				variable.Description = constantString(block.Body.Attributes["description"])
+				variable.Sensitive = constantBool(block.Body.Attributes["sensitive"])
+				out.Module.Variables[block.Labels[0]] = variable
+			case "output":
+				if len(block.Labels) != 1 {
+					continue
+				}
+				output := &tfjson.ConfigOutput{
+					Description: constantString(block.Body.Attributes["description"]),
+					Sensitive:   constantBool(block.Body.Attributes["sensitive"]),
+					DependsOn:   dependsOn(block.Body.Attributes["depends_on"]),
+				}
+				if attr, ok := block.Body.Attributes["value"]; ok {
+					output.Expression = decodeExpression(attr.Expr)
+				}
+				out.Module.Outputs[block.Labels[0]] = output
+			case "provider":
+				if len(block.Labels) != 1 {
+					continue
+				}
+				name := block.Labels[0]
+				providerConfig := &tfjson.ProviderConfig{
+					Name:              name,
+					FullName:          providerSource(out.ProviderSources, name),
+					Alias:             constantString(block.Body.Attributes["alias"]),
+					ModuleAddress:     address,
+					Expressions:       decodeBody(block.Body, "alias", "version"),
+					VersionConstraint: constantString(block.Body.Attributes["version"]),
+				}
+				key := name
+				if providerConfig.Alias != "" {
+					key = name + "." + providerConfig.Alias
+				}
+				out.Providers[key] = true
+				out.ProviderConfigs[providerConfigKey(address, key)] = providerConfig
+			case "locals":
+				for name, attr := range block.Body.Attributes {
+					locals[name] = decodeExpression(attr.Expr)
+				}
+			}
+		}
+	}
+	resolveLocals(out, locals)
+
+	// terraform sorts the resources by address
+	sort.Slice(out.Module.Resources, func(i, j int) bool {
+		return out.Module.Resources[i].Address < out.Module.Resources[j].Address
+	})
+	return out, nil
+}
+
+func decodeConfigResource(block *hclsyntax.Block) *tfjson.ConfigResource {
+	resourceType, name := block.Labels[0], block.Labels[1]
+	out := &tfjson.ConfigResource{
+		Address:     fmt.Sprintf("%s.%s", resourceType, name),
+		Mode:        tfjson.ManagedResourceMode,
+		Type:        resourceType,
+		Name:        name,
+		Expressions: decodeBody(block.Body, "count", "for_each", "provider", "depends_on", "lifecycle", "provisioner", "connection"),
+		DependsOn:   dependsOn(block.Body.Attributes["depends_on"]),
+	}
+	if block.Type == "data" {
+		out.Address = "data." + out.Address
+		out.Mode = tfjson.DataResourceMode
+	}
+	if attr, ok := block.Body.Attributes["count"]; ok {
+		out.CountExpression = decodeExpression(attr.Expr)
+	}
+	if attr, ok := block.Body.Attributes["for_each"]; ok {
+		out.ForEachExpression = decodeExpression(attr.Expr)
+	}
+
+	// the provider local name defaults to the resource type prefix
+	out.ProviderConfigKey = strings.Split(resourceType, "_")[0]
+	if attr, ok := block.Body.Attributes["provider"]; ok {
+		if traversal, diags := hcl.AbsTraversalForExpr(attr.Expr); !diags.HasErrors() {
+			out.ProviderConfigKey = traversalString(traversal)
+		}
+	}
+	return out
+}
+
+func decodeModuleCall(block *hclsyntax.Block) *tfjson.ModuleCall {
+	out := &tfjson.ModuleCall{
+		Source:            constantString(block.Body.Attributes["source"]),
+		VersionConstraint: constantString(block.Body.Attributes["version"]),
+		Expressions:       decodeBody(block.Body, "source", "version", "count", "for_each", "providers", "depends_on"),
+		DependsOn:         dependsOn(block.Body.Attributes["depends_on"]),
+	}
+	if attr, ok := block.Body.Attributes["count"]; ok {
+		out.CountExpression = decodeExpression(attr.Expr)
+	}
+	if attr, ok := block.Body.Attributes["for_each"]; ok {
+		out.ForEachExpression = decodeExpression(attr.Expr)
+	}
+	return out
+}
+
+// moduleCallProviders returns the provider config keys passed to a module call, keyed by the provider config keys in the child module,
+// like `{ azurerm = azurerm.west }`.
+func moduleCallProviders(attr *hclsyntax.Attribute) map[string]string {
+	if attr == nil {
+		return nil
+	}
+	pairs, diags := hcl.ExprMap(attr.Expr)
+	if diags.HasErrors() {
+		return nil
+	}
+	out := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, diags := hcl.AbsTraversalForExpr(pair.Key)
+		if diags.HasErrors() {
+			continue
+		}
+		value, diags := hcl.AbsTraversalForExpr(pair.Value)
+		if diags.HasErrors() {
+			continue
+		}
+		out[traversalString(key)] = traversalString(value)
+	}
+	return out
+}
+
+// decodeBody decodes the attributes and nested blocks of a block body, except for the meta-arguments to skip.
+// Dynamic blocks are skipped because their content is only known after expansion.
+func decodeBody(body *hclsyntax.Body, skip ...string) map[string]*tfjson.Expression {
+	skipped := make(map[string]bool)
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	out := make(map[string]*tfjson.Expression)
+	for name, attr := range body.Attributes {
+		if skipped[name] {
+			continue
+		}
+		out[name] = decodeExpression(attr.Expr)
+	}
+	for _, block := range body.Blocks {
+		if skipped[block.Type] || block.Type == "dynamic" {
+			continue
+		}
+		expr, ok := out[block.Type]
+		if !ok {
+			expr = &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{}}
+			out[block.Type] = expr
+		}
+		expr.NestedBlocks = append(expr.NestedBlocks, decodeBody(block.Body))
+	}
+	return out
+}
+
+// singleReferences is the set of the expressions read from the binary plan files which are a single reference,
+// like `azurerm_resource_group.test.name` or `"${azurerm_resource_group.test.name}"`, keyed by their expression data.
+// Their values are the values of their references, unlike the templates or the function calls with the same references,
+// like `"${azurerm_resource_group.test.name}-vnet"`. The JSON plan doesn't tell them apart.
+var singleReferences sync.Map
+
+// IsSingleReference reports whether the expression is a single reference in the configuration of a binary plan file, see singleReferences.
+// It returns false for the expressions of the JSON plans.
+func IsSingleReference(expr *tfjson.Expression) bool {
+	if expr == nil || expr.ExpressionData == nil {
+		return false
+	}
+	_, ok := singleReferences.Load(expr.ExpressionData)
+	return ok
+}
+
+// decodeExpression decodes an expression to its constant value, or to the references it depends on.
+func decodeExpression(expr hclsyntax.Expression) *tfjson.Expression {
+	out := &tfjson.Expression{ExpressionData: &tfjson.ExpressionData{}}
+	references := countIndexReferences(expr)
+	for _, traversal := range expr.Variables() {
+		references = append(references, traversalReferences(traversal)...)
+	}
+	if len(references) > 0 {
+		out.ConstantValue = tfjson.UnknownConstantValue
+		out.References = references
+		if isSingleReference(expr) {
+			singleReferences.Store(out.ExpressionData, true)
+		}
+		return out
+	}
+
+	// expressions calling functions can't be evaluated without the terraform functions, they're left empty like terraform does
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() || !value.IsWhollyKnown() {
+		return out
+	}
+	out.ConstantValue = ctyToGo(value)
+	return out
+}
+
+// ctyToGo converts a cty value to the same Go value as decoding its JSON.
+func ctyToGo(value cty.Value) interface{} {
+	if value.IsNull() {
+		return nil
+	}
+	data, err := ctyjson.Marshal(value, value.Type())
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// traversalReferences returns the references of a traversal in the format of the JSON plan.
+// The full reference comes first, followed by the shorter references it contains down to the referenced object,
+// e.g. `azurerm_subnet.s["a"].id`, `azurerm_subnet.s["a"]` and `azurerm_subnet.s`.
+func traversalReferences(traversal hcl.Traversal) []string {
+	subjectLen := 2
+	containerLen := 0
+	switch traversal.RootName() {
+	case "self":
+		subjectLen = 1
+	case "var", "local", "each", "count", "path", "terraform":
+	case "data":
+		subjectLen = 3
+		if len(traversal) > subjectLen && isIndex(traversal[subjectLen]) {
+			containerLen = subjectLen
+			subjectLen++
+		}
+	case "module":
+		if len(traversal) > subjectLen && isIndex(traversal[subjectLen]) {
+			containerLen = subjectLen
+			subjectLen++
+		}
+		if len(traversal) > subjectLen {
+			if _, ok := traversal[subjectLen].(hcl.TraverseAttr); ok {
+				// module output, the module call instance contains it
+				containerLen = subjectLen
+				subjectLen++
+			}
+		}
+	default:
+		if len(traversal) > subjectLen && isIndex(traversal[subjectLen]) {
+			containerLen = subjectLen
+			subjectLen++
+		}
+	}
+	if len(traversal) < subjectLen {
+		return nil
+	}
+
+	out := make([]string, 0)
+	for i := len(traversal); i >= subjectLen; i-- {
+		out = append(out, traversalString(traversal[:i]))
+	}
+	if containerLen > 0 {
+		out = append(out, traversalString(traversal[:containerLen]))
+	}
+	return out
+}
+
+// isSingleReference reports whether the expression is a traversal, like `azurerm_subnet.test["a"].id`, a traversal indexed by `count.index`,
+// a template which only interpolates one of them, like `"${azurerm_subnet.test.id}"`, or a list of one of them, like `[azurerm_subnet.test.id]`.
+func isSingleReference(expr hclsyntax.Expression) bool {
+	switch v := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		return true
+	case *hclsyntax.TemplateWrapExpr:
+		return isSingleReference(v.Wrapped)
+	case *hclsyntax.TupleConsExpr:
+		return len(v.Exprs) == 1 && isSingleReference(v.Exprs[0])
+	}
+	_, ok := countIndexReference(expr)
+	return ok
+}
+
+// countIndexReferences returns the references indexed by `count.index` in the expression, like `azurerm_subnet.s[count.index].id`
+// and `azurerm_subnet.s[count.index]`. The JSON plan only has the references before the index, like `azurerm_subnet.s`,
+// the index is kept so the reference can be resolved with the instance key of the resource.
+func countIndexReferences(expr hclsyntax.Expression) []string {
+	out := make([]string, 0)
+	_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+		if expr, ok := node.(hclsyntax.Expression); ok {
+			if reference, ok := countIndexReference(expr); ok {
+				out = append(out, reference)
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+func countIndexReference(expr hclsyntax.Expression) (string, bool) {
+	switch v := expr.(type) {
+	case *hclsyntax.IndexExpr:
+		key, ok := v.Key.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || traversalString(key.Traversal) != "count.index" {
+			return "", false
+		}
+		if collection, ok := v.Collection.(*hclsyntax.ScopeTraversalExpr); ok {
+			return traversalString(collection.Traversal) + "[count.index]", true
+		}
+		if collection, ok := countIndexReference(v.Collection); ok {
+			return collection + "[count.index]", true
+		}
+	case *hclsyntax.RelativeTraversalExpr:
+		if source, ok := countIndexReference(v.Source); ok {
+			return source + traversalString(v.Traversal), true
+		}
+	}
+	return "", false
+}
+
+// resolveLocals replaces the references to the locals in the module with the references of the local values,
+// because the configuration in the JSON plan has no locals, e.g. `local.subnet_id` is replaced by `azurerm_subnet.test.id`.
+func resolveLocals(module *configModule, locals map[string]*tfjson.Expression) {
+	if len(locals) == 0 {
+		return
+	}
+	resolver := &localResolver{locals: locals, resolved: make(map[string]resolvedLocal)}
+	for _, resource := range module.Module.Resources {
+		resolver.resolveExpressions(resource.Expressions)
+		resolver.resolveExpression(resource.CountExpression)
+		resolver.resolveExpression(resource.ForEachExpression)
+	}
+	for _, call := range module.Module.ModuleCalls {
+		resolver.resolveExpressions(call.Expressions)
+		resolver.resolveExpression(call.CountExpression)
+		resolver.resolveExpression(call.ForEachExpression)
+	}
+	for _, output := range module.Module.Outputs {
+		resolver.resolveExpression(output.Expression)
+	}
+	for _, providerConfig := range module.ProviderConfigs {
+		resolver.resolveExpressions(providerConfig.Expressions)
+	}
+}
+
+type localResolver struct {
+	locals map[string]*tfjson.Expression
+	// resolved is the local values whose references to other locals are resolved, keyed by the local name
+	resolved map[string]resolvedLocal
+}
+
+type resolvedLocal struct {
+	References []string
+	// SingleReference is true if the local value is a single reference, through the locals it refers to
+	SingleReference bool
+}
+
+func (r *localResolver) resolveExpressions(exprs map[string]*tfjson.Expression) {
+	for _, expr := range exprs {
+		r.resolveExpression(expr)
+	}
+}
+
+func (r *localResolver) resolveExpression(expr *tfjson.Expression) {
+	if expr == nil || expr.ExpressionData == nil {
+		return
+	}
+	if len(expr.References) > 0 {
+		references, single := r.resolveReferences(expr.References, 0)
+		expr.References = references
+		if !single {
+			// a single reference to a local whose value is a template isn't a single reference
+			singleReferences.Delete(expr.ExpressionData)
+		}
+	}
+	for _, block := range expr.NestedBlocks {
+		r.resolveExpressions(block)
+	}
+}
+
+// resolveReferences returns the references with the references to locals replaced, without duplicates.
+// It also reports whether all the referenced locals are single references.
+func (r *localResolver) resolveReferences(references []string, depth int) ([]string, bool) {
+	out := make([]string, 0, len(references))
+	seen := make(map[string]bool)
+	single := true
+	for _, reference := range references {
+		resolved := []string{reference}
+		if strings.HasPrefix(reference, "local.") {
+			var ok bool
+			resolved, ok = r.resolveLocal(reference, depth)
+			single = single && ok
+		}
+		for _, reference := range resolved {
+			if !seen[reference] {
+				seen[reference] = true
+				out = append(out, reference)
+			}
+		}
+	}
+	return out, single
+}
+
+// resolveLocal returns the references of a reference to a local, like `local.subnets["a"].id`,
+// the rest of the reference after the local name is appended to the references of the local value.
+// It also reports whether the local value is a single reference.
+func (r *localResolver) resolveLocal(reference string, depth int) ([]string, bool) {
+	name := strings.TrimPrefix(reference, "local.")
+	rest := ""
+	if index := strings.IndexAny(name, ".["); index >= 0 {
+		name, rest = name[:index], name[index:]
+	}
+	resolved, ok := r.resolved[name]
+	if !ok {
+		local, ok := r.locals[name]
+		if !ok || depth > maxLocalDepth {
+			return nil, false
+		}
+		references, single := r.resolveReferences(local.References, depth+1)
+		resolved = resolvedLocal{References: references, SingleReference: single && IsSingleReference(local)}
+		r.resolved[name] = resolved
+	}
+	out := make([]string, 0, len(resolved.References))
+	for _, reference := range resolved.References {
+		out = append(out, reference+rest)
+	}
+	return out, resolved.SingleReference
+}
+
+func isIndex(step hcl.Traverser) bool {
+	_, ok := step.(hcl.TraverseIndex)
+	return ok
+}
+
+func traversalString(traversal hcl.Traversal) string {
+	var out strings.Builder
+	for _, step := range traversal {
+		switch v := step.(type) {
+		case hcl.TraverseRoot:
+			out.WriteString(v.Name)
+		case hcl.TraverseAttr:
+			out.WriteString("." + v.Name)
+		case hcl.TraverseIndex:
+			switch {
+			case v.Key.Type() == cty.String && v.Key.IsKnown():
+				out.WriteString("[" + quoteString(v.Key.AsString()) + "]")
+			case v.Key.Type() == cty.Number && v.Key.IsKnown():
+				out.WriteString("[" + v.Key.AsBigFloat().Text('f', -1) + "]")
+			default:
+				out.WriteString("[...]")
+			}
+		}
+	}
+	return out.String()
+}
+
+// dependsOn returns the addresses in the `depends_on` meta-argument.
+func dependsOn(attr *hclsyntax.Attribute) []string {
+	if attr == nil {
+		return nil
+	}
+	exprs, diags := hcl.ExprList(attr.Expr)
+	if diags.HasErrors() {
+		return nil
+	}
+	out := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		if traversal, diags := hcl.AbsTraversalForExpr(expr); !diags.HasErrors() {
+			out = append(out, traversalString(traversal))
+		}
+	}
+	return out
+}
+
+// requiredProviderSource returns the full source address of a `required_providers` entry, like `registry.terraform.io/hashicorp/azurerm`.
+func requiredProviderSource(expr hclsyntax.Expression) string {
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() || !value.IsWhollyKnown() || value.IsNull() || !value.Type().IsObjectType() || !value.Type().HasAttribute("source") {
+		return ""
+	}
+	source := value.GetAttr("source")
+	if source.IsNull() || source.Type() != cty.String {
+		return ""
+	}
+	parts := strings.Split(strings.ToLower(source.AsString()), "/")
+	switch len(parts) {
+	case 1:
+		return fmt.Sprintf("%s/hashicorp/%s", defaultRegistryHost, parts[0])
+	case 2:
+		return fmt.Sprintf("%s/%s/%s", defaultRegistryHost, parts[0], parts[1])
+	}
+	return strings.Join(parts, "/")
+}
+
+func constantString(attr *hclsyntax.Attribute) string {
+	if attr == nil {
+		return ""
+	}
+	if v, ok := decodeExpression(attr.Expr).ConstantValue.(string); ok {
+		return v
+	}
+	return ""
+}
+
+func constantBool(attr *hclsyntax.Attribute) bool {
+	if attr == nil {
+		return false
+	}
+	if v, ok := decodeExpression(attr.Expr).ConstantValue.(bool); ok {
+		return v
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package planfile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func Test_decodeExpression(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect *tfjson.ExpressionData
+	}{
+		{
+			Input:  `"westeurope"`,
+			Expect: &tfjson.ExpressionData{ConstantValue: "westeurope"},
+		},
+		{
+			Input:  `{ a = [1, true] }`,
+			Expect: &tfjson.ExpressionData{ConstantValue: map[string]interface{}{"a": []interface{}{float64(1), true}}},
+		},
+		{
+			Input:  `lower("A")`,
+			Expect: &tfjson.ExpressionData{},
+		},
+		{
+			Input: `"acctest-${var.name}"`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"var.name"},
+			},
+		},
+		{
+			Input: `azurerm_resource_group.test.name`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"azurerm_resource_group.test.name", "azurerm_resource_group.test"},
+			},
+		},
+		{
+			Input: `azurerm_subnet.s["a"].id`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{`azurerm_subnet.s["a"].id`, `azurerm_subnet.s["a"]`, "azurerm_subnet.s"},
+			},
+		},
+		{
+			Input: `azurerm_subnet.s["ü$${x}\n"].id`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{`azurerm_subnet.s["ü$${x}\n"].id`, `azurerm_subnet.s["ü$${x}\n"]`, "azurerm_subnet.s"},
+			},
+		},
+		{
+			Input: `data.azurerm_client_config.current.tenant_id`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"data.azurerm_client_config.current.tenant_id", "data.azurerm_client_config.current"},
+			},
+		},
+		{
+			Input: `module.network[0].subnet_id`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"module.network[0].subnet_id", "module.network[0]"},
+			},
+		},
+		{
+			Input: `each.value.name`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"each.value.name", "each.value"},
+			},
+		},
+		{
+			Input: `azurerm_subnet.s[count.index].id`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"azurerm_subnet.s[count.index].id", "azurerm_subnet.s[count.index]", "azurerm_subnet.s", "count.index"},
+			},
+		},
+		{
+			Input: `[for s in var.subnets : s.id]`,
+			Expect: &tfjson.ExpressionData{
+				ConstantValue: tfjson.UnknownConstantValue,
+				References:    []string{"var.subnets"},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		expr, diags := hclsyntax.ParseExpression([]byte(testcase.Input), "test.tf", hcl.InitialPos)
+		if diags.HasErrors() {
+			t.Fatalf("Failed to parse expression %q: %s", testcase.Input, diags.Error())
+		}
+		actual := decodeExpression(expr)
+		if !reflect.DeepEqual(actual.ExpressionData, testcase.Expect) {
+			t.Fatalf("Expected %+v for input %q, got %+v", testcase.Expect, testcase.Input, actual.ExpressionData)
+		}
+	}
+}
+
+func Test_decodeConfig_Modules(t *testing.T) {
+	files := map[string][]byte{
+		"tfconfig/modules.json": []byte(`[{"Key":"","Dir":"."},{"Key":"network","Source":"./network","Dir":"network"}]`),
+		"tfconfig/m-/main.tf": []byte(`
+provider "azurerm" {
+  features {}
+}
+
+provider "azurerm" {
+  alias = "hub"
+  features {}
+}
+
+module "network" {
+  source   = "./network"
+  name     = var.name
+  for_each = toset(["a"])
+}
+`),
+		"tfconfig/m-network/main.tf": []byte(`
+variable "name" {}
+
+resource "azurerm_virtual_network" "test" {
+  name = var.name
+  dynamic "subnet" {
+    for_each = []
+    content {}
+  }
+}
+
+resource "azurerm_virtual_network_peering" "test" {
+  provider = azurerm.hub
+  name     = "peering"
+}
+
+output "id" {
+  value = azurerm_virtual_network.test.id
+}
+`),
+	}
+
+	config, err := decodeConfig(files)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	call := config.RootModule.ModuleCalls["network"]
+	if call == nil || call.Module == nil || call.Source != "./network" || call.ForEachExpression == nil {
+		t.Fatalf("Expected module call network with the child module, got %+v", call)
+	}
+	if _, ok := call.Expressions["name"]; !ok {
+		t.Fatalf("Expected module call expression name, got %v", call.Expressions)
+	}
+	resources := call.Module.Resources
+	if len(resources) != 2 || resources[0].Address != "azurerm_virtual_network.test" {
+		t.Fatalf("Expected 2 resources in module network, got %v", resources)
+	}
+	if resources[0].ProviderConfigKey != "azurerm" || resources[1].ProviderConfigKey != "azurerm.hub" {
+		t.Fatalf("Expected provider config keys azurerm and azurerm.hub, got %s and %s", resources[0].ProviderConfigKey, resources[1].ProviderConfigKey)
+	}
+	if _, ok := resources[0].Expressions["subnet"]; ok {
+		t.Fatalf("Expected dynamic block to be skipped")
+	}
+	if config.ProviderConfigs["azurerm.hub"] == nil || config.ProviderConfigs["azurerm.hub"].Alias != "hub" {
+		t.Fatalf("Expected provider config azurerm.hub, got %v", config.ProviderConfigs)
+	}
+	if output := call.Module.Outputs["id"]; output == nil || len(output.Expression.References) != 2 {
+		t.Fatalf("Expected output id with references, got %+v", output)
+	}
+}
+
+func Test_decodeConfig_Locals(t *testing.T) {
+	files := map[string][]byte{
+		"tfconfig/modules.json": []byte(`[{"Key":"","Dir":"."}]`),
+		"tfconfig/m-/locals.tf": []byte(`
+locals {
+  location  = "westeurope"
+  subnet_id = azurerm_subnet.test.id
+  subnets   = local.subnet_ids
+}
+`),
+		"tfconfig/m-/main.tf": []byte(`
+locals {
+  subnet_ids = azurerm_subnet.test[*].id
+}
+
+resource "azurerm_network_interface" "test" {
+  count    = length(local.subnets)
+  location = local.location
+  ip_configuration {
+    subnet_id = local.subnet_id
+  }
+}
+
+output "subnet_id" {
+  value = local.subnets[count.index]
+}
+`),
+	}
+
+	config, err := decodeConfig(files)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resource := config.RootModule.Resources[0]
+	if actual := resource.CountExpression.References; !reflect.DeepEqual(actual, []string{"azurerm_subnet.test"}) {
+		t.Fatalf("Expected the count references from the nested locals, got %v", actual)
+	}
+	if actual := resource.Expressions["location"].References; len(actual) != 0 {
+		t.Fatalf("Expected no references for a constant local, got %v", actual)
+	}
+	expect := []string{"azurerm_subnet.test.id", "azurerm_subnet.test"}
+	if actual := resource.Expressions["ip_configuration"].NestedBlocks[0]["subnet_id"].References; !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+	expect = []string{"azurerm_subnet.test[count.index]", "azurerm_subnet.test", "count.index"}
+	if actual := config.RootModule.Outputs["subnet_id"].Expression.References; !reflect.DeepEqual(actual, expect) {
+		t.Fatalf("Expected %v, got %v", expect, actual)
+	}
+}
+
+func Test_decodeConfig_SingleReferences(t *testing.T) {
+	files := map[string][]byte{
+		"tfconfig/modules.json": []byte(`[{"Key":"","Dir":"."}]`),
+		"tfconfig/m-/main.tf": []byte(`
+locals {
+  name      = azurerm_resource_group.test.name
+  vnet_name = "${azurerm_resource_group.test.name}-vnet"
+}
+
+resource "azurerm_virtual_network" "test" {
+  name                = "${azurerm_resource_group.test.name}-vnet"
+  resource_group_name = azurerm_resource_group.test.name
+  location            = "${azurerm_resource_group.test.location}"
+  address_space       = [azurerm_resource_group.test.tags["cidr"]]
+  dns_servers         = azurerm_resource_group.test[*].tags["dns"]
+  bgp_community       = lower(azurerm_resource_group.test.tags["bgp"])
+  local_name          = local.name
+  local_vnet_name     = local.vnet_name
+}
+`),
+	}
+
+	config, err := decodeConfig(files)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expressions := config.RootModule.Resources[0].Expressions
+	testcases := []struct {
+		Name   string
+		Expect bool
+	}{
+		{Name: "name", Expect: false},
+		{Name: "resource_group_name", Expect: true},
+		{Name: "location", Expect: true},
+		{Name: "address_space", Expect: true},
+		{Name: "dns_servers", Expect: false},
+		{Name: "bgp_community", Expect: false},
+		{Name: "local_name", Expect: true},
+		// a reference to a local whose value is a template isn't a single reference
+		{Name: "local_vnet_name", Expect: false},
+	}
+	for _, testcase := range testcases {
+		if actual := IsSingleReference(expressions[testcase.Name]); actual != testcase.Expect {
+			t.Errorf("Expected %s to be a single reference: %v, got %v", testcase.Name, testcase.Expect, actual)
+		}
+	}
+	if IsSingleReference(&tfjson.Expression{ExpressionData: &tfjson.ExpressionData{References: []string{"azurerm_resource_group.test.name"}}}) {
+		t.Errorf("Expected an expression which isn't read from a binary plan file not to be a single reference")
+	}
+}
+
+func Test_decodeConfig_ModuleCallProviders(t *testing.T) {
+	files := map[string][]byte{
+		"tfconfig/modules.json": []byte(`[{"Key":"","Dir":"."},{"Key":"spoke","Source":"./spoke","Dir":"spoke"},{"Key":"spoke.net","Source":"./net","Dir":"spoke/net"}]`),
+		"tfconfig/m-/main.tf": []byte(`
+provider "azurerm" {
+  features {}
+}
+
+provider "azurerm" {
+  alias = "west"
+  features {}
+}
+
+module "spoke" {
+  source = "./spoke"
+  providers = {
+    azurerm     = azurerm.west
+    azurerm.hub = azurerm
+  }
+}
+`),
+		"tfconfig/m-spoke/main.tf": []byte(`
+resource "azurerm_resource_group" "test" {
+  name = "spoke"
+}
+
+resource "azurerm_virtual_network_peering" "test" {
+  provider = azurerm.hub
+  name     = "peering"
+}
+
+module "net" {
+  source = "./net"
+}
+`),
+		"tfconfig/m-spoke.net/main.tf": []byte(`
+resource "azurerm_virtual_network" "test" {
+  name = "net"
+}
+`),
+	}
+
+	config, err := decodeConfig(files)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	spoke := config.RootModule.ModuleCalls["spoke"].Module
+	expect := map[string]string{
+		"azurerm_resource_group.test":          "azurerm.west",
+		"azurerm_virtual_network_peering.test": "azurerm",
+	}
+	for _, resource := range spoke.Resources {
+		if resource.ProviderConfigKey != expect[resource.Address] {
+			t.Fatalf("Expected provider config key %s for %s, got %s", expect[resource.Address], resource.Address, resource.ProviderConfigKey)
+		}
+	}
+	if actual := spoke.ModuleCalls["net"].Module.Resources[0].ProviderConfigKey; actual != "azurerm.west" {
+		t.Fatalf("Expected the provider config key azurerm.west to be passed to the nested module, got %s", actual)
+	}
+}
@@ -0,0 +1,269 @@
+package planfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// unknownValue marks a value that is unknown until apply. Terraform encodes it as a msgpack extension.
+type unknownValue struct{}
+
+// decodeMsgpack decodes a msgpack encoded cty value without its schema.
+// Numbers are returned as float64, unknown values as unknownValue, and dynamically typed values
+// (encoded as a [type, value] pair) as the value itself.
+func decodeMsgpack(data []byte) (interface{}, error) {
+	d := &msgpackDecoder{data: data}
+	out, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(d.data)-d.pos)
+	}
+	return out, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return float64(code), nil
+	case code >= 0xe0:
+		return float64(int8(code)), nil
+	case code >= 0x80 && code <= 0x8f:
+		return d.decodeMap(int(code & 0x0f))
+	case code >= 0x90 && code <= 0x9f:
+		return d.decodeArray(int(code & 0x0f))
+	case code >= 0xa0 && code <= 0xbf:
+		return d.decodeString(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(code - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, v...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLength(code - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		v, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(v))), nil
+	case 0xcb:
+		v, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(v)), nil
+	case 0xcc:
+		v, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return float64(v[0]), nil
+	case 0xcd:
+		v, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(binary.BigEndian.Uint16(v)), nil
+	case 0xce:
+		v, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(binary.BigEndian.Uint32(v)), nil
+	case 0xcf:
+		v, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return float64(binary.BigEndian.Uint64(v)), nil
+	case 0xd0:
+		v, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int8(v[0])), nil
+	case 0xd1:
+		v, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int16(binary.BigEndian.Uint16(v))), nil
+	case 0xd2:
+		v, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int32(binary.BigEndian.Uint32(v))), nil
+	case 0xd3:
+		v, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int64(binary.BigEndian.Uint64(v))), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (code - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(code - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLength(code - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLength(code - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported code 0x%x at position %d", code, d.pos-1)
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, fmt.Errorf("msgpack: unexpected end of data at position %d", d.pos)
+	}
+	out := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return out, nil
+}
+
+// readLength reads a big endian length of 1, 2 or 4 bytes, selected by size 0, 1 or 2.
+func (d *msgpackDecoder) readLength(size byte) (int, error) {
+	v, err := d.read(1 << size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 0:
+		return int(v[0]), nil
+	case 1:
+		return int(binary.BigEndian.Uint16(v)), nil
+	default:
+		return int(binary.BigEndian.Uint32(v)), nil
+	}
+}
+
+func (d *msgpackDecoder) decodeString(n int) (interface{}, error) {
+	v, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
+// decodeExt decodes an extension value. cty only uses extensions for unknown values, optionally with refinements.
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	if _, err := d.read(1 + n); err != nil {
+		return nil, err
+	}
+	return unknownValue{}, nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int) (interface{}, error) {
+	out := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	// cty encodes dynamically typed values as [type JSON in binary, value], binary isn't used otherwise
+	if len(out) == 2 {
+		if _, ok := out[0].([]byte); ok {
+			return out[1], nil
+		}
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (interface{}, error) {
+	out := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: unsupported map key type %T", k)
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	return out, nil
+}
+
+// splitUnknowns splits a decoded value into the value with unknowns omitted, and the matching
+// `after_unknown` structure, the same way `terraform show -json` does.
+func splitUnknowns(input interface{}) (value interface{}, unknown interface{}) {
+	switch v := input.(type) {
+	case unknownValue:
+		return nil, true
+	case map[string]interface{}:
+		values := make(map[string]interface{})
+		unknowns := make(map[string]interface{})
+		for key, item := range v {
+			itemValue, itemUnknown := splitUnknowns(item)
+			if itemUnknown == true {
+				unknowns[key] = true
+				continue
+			}
+			values[key] = itemValue
+			if itemUnknown != false {
+				unknowns[key] = itemUnknown
+			}
+		}
+		return values, unknowns
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		unknowns := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			itemValue, itemUnknown := splitUnknowns(item)
+			values = append(values, itemValue)
+			unknowns = append(unknowns, itemUnknown)
+		}
+		return values, unknowns
+	}
+	return input, false
+}
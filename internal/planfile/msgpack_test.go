@@ -0,0 +1,94 @@
+package planfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_decodeMsgpack(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Input  []byte
+		Expect interface{}
+	}{
+		{
+			Name: "object with unknown attribute",
+			// {"id": unknown, "name": "a", "count": 300}
+			Input:  []byte{0x83, 0xa2, 'i', 'd', 0xd4, 0x00, 0x00, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'a', 0xa5, 'c', 'o', 'u', 'n', 't', 0xcd, 0x01, 0x2c},
+			Expect: map[string]interface{}{"id": unknownValue{}, "name": "a", "count": float64(300)},
+		},
+		{
+			Name: "dynamic value",
+			// ["\"string\"" in binary, "a"]
+			Input:  []byte{0x92, 0xc4, 0x08, '"', 's', 't', 'r', 'i', 'n', 'g', '"', 0xa1, 'a'},
+			Expect: "a",
+		},
+		{
+			Name: "list with nil and negative number",
+			// [nil, -1, true]
+			Input:  []byte{0x93, 0xc0, 0xff, 0xc3},
+			Expect: []interface{}{nil, float64(-1), true},
+		},
+		{
+			Name:   "refined unknown",
+			Input:  []byte{0xc7, 0x02, 0x0c, 0x81, 0x01},
+			Expect: unknownValue{},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			actual, err := decodeMsgpack(testcase.Input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, testcase.Expect) {
+				t.Fatalf("Expected %#v, got %#v", testcase.Expect, actual)
+			}
+		})
+	}
+
+	if _, err := decodeMsgpack([]byte{0x82, 0xa1}); err == nil {
+		t.Fatalf("Expected error for truncated data, got nil")
+	}
+}
+
+func Test_splitUnknowns(t *testing.T) {
+	input := map[string]interface{}{
+		"id":   unknownValue{},
+		"name": "a",
+		"identity": []interface{}{
+			map[string]interface{}{
+				"type":         "UserAssigned",
+				"principal_id": unknownValue{},
+			},
+		},
+		"tags": map[string]interface{}{},
+	}
+
+	value, unknown := splitUnknowns(input)
+	expectValue := map[string]interface{}{
+		"name": "a",
+		"identity": []interface{}{
+			map[string]interface{}{
+				"type": "UserAssigned",
+			},
+		},
+		"tags": map[string]interface{}{},
+	}
+	expectUnknown := map[string]interface{}{
+		"id": true,
+		"identity": []interface{}{
+			map[string]interface{}{
+				"principal_id": true,
+			},
+		},
+		"tags": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(value, expectValue) {
+		t.Fatalf("Expected value %v, got %v", expectValue, value)
+	}
+	if !reflect.DeepEqual(unknown, expectUnknown) {
+		t.Fatalf("Expected unknown %v, got %v", expectUnknown, unknown)
+	}
+}
@@ -0,0 +1,104 @@
+package planfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/Azure/aztfpreflight/internal/terraform"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/sirupsen/logrus"
+)
+
+// Stdin is the file path that reads the plan from stdin.
+const Stdin = "-"
+
+// Load reads the terraform plan from the file, or from stdin if the file path is "-".
+// The plan can be the JSON output of `terraform show -json`, or a binary plan file. Neither needs a terraform executable,
+// `terraform show` is only used when the binary plan file can't be read natively, e.g. it's written by an unsupported terraform version.
+func Load(ctx context.Context, filePath string) (*tfjson.Plan, error) {
+	var data []byte
+	var err error
+	if filePath == Stdin {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading plan file: %w", err)
+	}
+
+	if IsJSON(data) {
+		logrus.Infof("reading terraform plan in JSON format\n")
+		return ParseJSON(data)
+	}
+
+	if IsBinary(data) {
+		tfplan, err := ReadBinary(data)
+		if err == nil {
+			logrus.Infof("reading terraform plan in binary format\n")
+			return tfplan, nil
+		}
+		logrus.Warnf("failed to read the binary plan file, falling back to terraform show: %v\n", err)
+	}
+
+	workingDir := path.Dir(filePath)
+	if filePath == Stdin {
+		// terraform show requires a file, the current directory is used as the working directory
+		workingDir = "."
+		tempFile, err := os.CreateTemp("", "aztfpreflight-*.tfplan")
+		if err != nil {
+			return nil, fmt.Errorf("creating temporary plan file: %w", err)
+		}
+		defer func() { _ = os.Remove(tempFile.Name()) }()
+		if _, err := tempFile.Write(data); err != nil {
+			_ = tempFile.Close()
+			return nil, fmt.Errorf("writing temporary plan file: %w", err)
+		}
+		if err := tempFile.Close(); err != nil {
+			return nil, fmt.Errorf("writing temporary plan file: %w", err)
+		}
+		filePath = tempFile.Name()
+	}
+	return ShowPlanFile(ctx, workingDir, filePath)
+}
+
+// IsJSON reports whether the data looks like a JSON document rather than a binary plan file.
+func IsJSON(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// ParseJSON parses the output of `terraform show -json`.
+func ParseJSON(data []byte) (*tfjson.Plan, error) {
+	var tfplan tfjson.Plan
+	if err := json.Unmarshal(data, &tfplan); err != nil {
+		return nil, fmt.Errorf("parsing plan JSON: %w", err)
+	}
+	return &tfplan, nil
+}
+
+// ShowPlanFile converts the binary plan file to JSON by running `terraform show` in the working directory.
+func ShowPlanFile(ctx context.Context, workingDir string, filePath string) (*tfjson.Plan, error) {
+	execPath, err := terraform.FindTerraform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find terraform executable: %w", err)
+	}
+	logrus.Infof("terraform executable path: %s\n", execPath)
+
+	tf, err := tfexec.NewTerraform(workingDir, execPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create terraform client: %w", err)
+	}
+
+	tfplan, err := tf.ShowPlanFile(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to show plan file: %w", err)
+	}
+	return tfplan, nil
+}
@@ -0,0 +1,125 @@
+package planfile_test
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/planfile"
+)
+
+func Test_IsJSON(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect bool
+	}{
+		{
+			Input:  `{"format_version":"1.2"}`,
+			Expect: true,
+		},
+		{
+			Input:  "\n  {\"format_version\":\"1.2\"}",
+			Expect: true,
+		},
+		{
+			Input:  "PK\x03\x04",
+			Expect: false,
+		},
+		{
+			Input:  "",
+			Expect: false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		if actual := planfile.IsJSON([]byte(testcase.Input)); actual != testcase.Expect {
+			t.Fatalf("Expected %v for input %q, got %v", testcase.Expect, testcase.Input, actual)
+		}
+	}
+}
+
+func Test_Load_JSON(t *testing.T) {
+	tfplan, err := planfile.Load(context.TODO(), path.Join("testdata", "plan.json"))
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+	if len(tfplan.ResourceChanges) != 5 {
+		t.Fatalf("Expected 5 resource changes, got %d", len(tfplan.ResourceChanges))
+	}
+	if tfplan.Config == nil || tfplan.Config.RootModule == nil || len(tfplan.Config.RootModule.Resources) != 5 {
+		t.Fatalf("Expected the configuration of 5 resources")
+	}
+}
+
+func Test_IsBinary(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect bool
+	}{
+		{
+			Input:  "PK\x03\x04\x14\x00",
+			Expect: true,
+		},
+		{
+			Input:  `{"format_version":"1.2"}`,
+			Expect: false,
+		},
+		{
+			Input:  "",
+			Expect: false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		if actual := planfile.IsBinary([]byte(testcase.Input)); actual != testcase.Expect {
+			t.Fatalf("Expected %v for input %q, got %v", testcase.Expect, testcase.Input, actual)
+		}
+	}
+}
+
+func Test_Load_Binary(t *testing.T) {
+	actual, err := planfile.Load(context.TODO(), path.Join("testdata", "planfile"))
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+
+	// testdata/plan.json is the JSON output of testdata/planfile
+	expected, err := planfile.Load(context.TODO(), path.Join("testdata", "plan.json"))
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+
+	if len(actual.ResourceChanges) != len(expected.ResourceChanges) {
+		t.Fatalf("Expected %d resource changes, got %d", len(expected.ResourceChanges), len(actual.ResourceChanges))
+	}
+	for i, expectedChange := range expected.ResourceChanges {
+		actualChange := actual.ResourceChanges[i]
+		if actualChange.Address != expectedChange.Address || actualChange.Type != expectedChange.Type || actualChange.ProviderName != expectedChange.ProviderName {
+			t.Fatalf("Expected resource change %s of %s, got %s of %s", expectedChange.Address, expectedChange.ProviderName, actualChange.Address, actualChange.ProviderName)
+		}
+		if !reflect.DeepEqual(actualChange.Change.Actions, expectedChange.Change.Actions) {
+			t.Fatalf("Expected actions %v for %s, got %v", expectedChange.Change.Actions, expectedChange.Address, actualChange.Change.Actions)
+		}
+		if !reflect.DeepEqual(actualChange.Change.After, expectedChange.Change.After) {
+			t.Fatalf("Expected after %v for %s, got %v", expectedChange.Change.After, expectedChange.Address, actualChange.Change.After)
+		}
+		if !reflect.DeepEqual(actualChange.Change.AfterUnknown, expectedChange.Change.AfterUnknown) {
+			t.Fatalf("Expected after_unknown %v for %s, got %v", expectedChange.Change.AfterUnknown, expectedChange.Address, actualChange.Change.AfterUnknown)
+		}
+	}
+
+	actualConfig, _ := json.Marshal(actual.Config)
+	expectedConfig, _ := json.Marshal(expected.Config)
+	if string(actualConfig) != string(expectedConfig) {
+		t.Fatalf("Expected configuration %s, got %s", expectedConfig, actualConfig)
+	}
+
+	if actual.PriorState == nil || actual.PriorState.Values == nil || actual.PriorState.Values.RootModule == nil {
+		t.Fatalf("Expected an empty prior state")
+	}
+	if actual.Variables["random_string"] == nil || actual.Variables["random_string"].Value != "2mrdi" {
+		t.Fatalf("Expected variable random_string to be 2mrdi, got %v", actual.Variables["random_string"])
+	}
+}
@@ -0,0 +1,129 @@
+package planfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// stateV4 is the state file format written into the plan file, only the fields needed to build the JSON state are decoded.
+type stateV4 struct {
+	Version          int               `json:"version"`
+	TerraformVersion string            `json:"terraform_version"`
+	Resources        []stateV4Resource `json:"resources"`
+}
+
+type stateV4Resource struct {
+	Module    string            `json:"module"`
+	Mode      string            `json:"mode"`
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Provider  string            `json:"provider"`
+	Instances []stateV4Instance `json:"instances"`
+}
+
+type stateV4Instance struct {
+	IndexKey      interface{}            `json:"index_key"`
+	SchemaVersion uint64                 `json:"schema_version"`
+	Deposed       string                 `json:"deposed"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	Dependencies  []string               `json:"dependencies"`
+}
+
+// decodeState converts the state file in the plan file to the state in the JSON plan.
+// An empty state file, which is written when there's no prior state, returns nil.
+func decodeState(data []byte) (*tfjson.State, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var input stateV4
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("parsing state: %w", err)
+	}
+	if input.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d, expected 4", input.Version)
+	}
+
+	root := &tfjson.StateModule{}
+	modules := map[string]*tfjson.StateModule{"": root}
+	for _, resource := range input.Resources {
+		module, err := stateModule(modules, resource.Module)
+		if err != nil {
+			return nil, err
+		}
+		mode := tfjson.ResourceMode(resource.Mode)
+		for _, instance := range resource.Instances {
+			addr := resourceInstanceAddress{
+				ModuleAddress: resource.Module,
+				Mode:          mode,
+				Type:          resource.Type,
+				Name:          resource.Name,
+				Index:         instance.IndexKey,
+			}
+			module.Resources = append(module.Resources, &tfjson.StateResource{
+				Address:         joinAddress(resource.Module, addr.String()),
+				Mode:            mode,
+				Type:            resource.Type,
+				Name:            resource.Name,
+				Index:           instance.IndexKey,
+				ProviderName:    providerFullName(resource.Provider),
+				SchemaVersion:   instance.SchemaVersion,
+				AttributeValues: instance.Attributes,
+				DependsOn:       instance.Dependencies,
+				DeposedKey:      instance.Deposed,
+			})
+		}
+	}
+
+	for _, module := range modules {
+		sort.Slice(module.ChildModules, func(i, j int) bool {
+			return module.ChildModules[i].Address < module.ChildModules[j].Address
+		})
+	}
+
+	return &tfjson.State{
+		FormatVersion:    "1.0",
+		TerraformVersion: input.TerraformVersion,
+		Values: &tfjson.StateValues{
+			RootModule: root,
+		},
+	}, nil
+}
+
+// stateModule returns the state module of the module address, the parent modules are created if they don't exist.
+func stateModule(modules map[string]*tfjson.StateModule, moduleAddress string) (*tfjson.StateModule, error) {
+	if module, ok := modules[moduleAddress]; ok {
+		return module, nil
+	}
+	steps, err := SplitAddress(moduleAddress)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) < 2 || len(steps)%2 != 0 {
+		return nil, fmt.Errorf("invalid module address %q", moduleAddress)
+	}
+
+	parentAddress := ""
+	for i := 0; i+2 < len(steps); i += 2 {
+		parentAddress = joinAddress(parentAddress, fmt.Sprintf("%s.%s%s", steps[i].Name, steps[i+1].Name, FormatIndex(steps[i+1].Index)))
+	}
+	parent, err := stateModule(modules, parentAddress)
+	if err != nil {
+		return nil, err
+	}
+	module := &tfjson.StateModule{
+		Address: moduleAddress,
+	}
+	parent.ChildModules = append(parent.ChildModules, module)
+	modules[moduleAddress] = module
+	return module, nil
+}
+
+func joinAddress(moduleAddress string, address string) string {
+	if moduleAddress == "" {
+		return address
+	}
+	return moduleAddress + "." + address
+}
@@ -0,0 +1,241 @@
+package planfile
+
+import (
+	"fmt"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// tfplanFormatVersion is the version of the `tfplan` protobuf message that can be read,
+// it's used by terraform 1.x and opentofu.
+const tfplanFormatVersion = 3
+
+// field numbers of terraform's planfile.proto
+const (
+	planVersionField          = 1
+	planVariablesField        = 2
+	planResourceChangesField  = 3
+	planTerraformVersionField = 14
+
+	mapKeyField   = 1
+	mapValueField = 2
+
+	dynamicValueMsgpackField = 1
+
+	resourceChangeDeposedKeyField  = 7
+	resourceChangeProviderField    = 8
+	resourceChangeChangeField      = 9
+	resourceChangeAddrField        = 13
+	resourceChangePrevRunAddrField = 14
+
+	changeActionField = 1
+	changeValuesField = 2
+)
+
+// planproto Action values
+const (
+	protoActionNoop             = 0
+	protoActionCreate           = 1
+	protoActionRead             = 2
+	protoActionUpdate           = 3
+	protoActionDelete           = 5
+	protoActionDeleteThenCreate = 6
+	protoActionCreateThenDelete = 7
+	protoActionForget           = 8
+)
+
+type tfplan struct {
+	Version          uint64
+	TerraformVersion string
+	Variables        map[string][]byte
+	ResourceChanges  []tfplanResourceChange
+}
+
+type tfplanResourceChange struct {
+	Addr        string
+	PrevRunAddr string
+	DeposedKey  string
+	Provider    string
+	Action      uint64
+	Values      [][]byte
+}
+
+// decodeTfplan decodes the `tfplan` protobuf message of the plan file.
+func decodeTfplan(data []byte) (*tfplan, error) {
+	out := tfplan{
+		Variables: make(map[string][]byte),
+	}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == planVersionField && typ == protowire.VarintType:
+			out.Version = varint
+		case num == planTerraformVersionField && typ == protowire.BytesType:
+			out.TerraformVersion = string(value)
+		case num == planVariablesField && typ == protowire.BytesType:
+			key, dynamicValue, err := decodeVariable(value)
+			if err != nil {
+				return fmt.Errorf("decoding variable: %w", err)
+			}
+			out.Variables[key] = dynamicValue
+		case num == planResourceChangesField && typ == protowire.BytesType:
+			change, err := decodeResourceChange(value)
+			if err != nil {
+				return fmt.Errorf("decoding resource change: %w", err)
+			}
+			out.ResourceChanges = append(out.ResourceChanges, *change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Version != tfplanFormatVersion {
+		return nil, fmt.Errorf("unsupported plan format version %d, expected %d", out.Version, tfplanFormatVersion)
+	}
+	return &out, nil
+}
+
+func decodeVariable(data []byte) (string, []byte, error) {
+	var key string
+	var msgpack []byte
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == mapKeyField && typ == protowire.BytesType:
+			key = string(value)
+		case num == mapValueField && typ == protowire.BytesType:
+			v, err := decodeDynamicValue(value)
+			if err != nil {
+				return err
+			}
+			msgpack = v
+		}
+		return nil
+	})
+	return key, msgpack, err
+}
+
+func decodeDynamicValue(data []byte) ([]byte, error) {
+	var msgpack []byte
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		if num == dynamicValueMsgpackField && typ == protowire.BytesType {
+			msgpack = value
+		}
+		return nil
+	})
+	return msgpack, err
+}
+
+func decodeResourceChange(data []byte) (*tfplanResourceChange, error) {
+	var out tfplanResourceChange
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case resourceChangeAddrField:
+			out.Addr = string(value)
+		case resourceChangePrevRunAddrField:
+			out.PrevRunAddr = string(value)
+		case resourceChangeDeposedKeyField:
+			out.DeposedKey = string(value)
+		case resourceChangeProviderField:
+			out.Provider = string(value)
+		case resourceChangeChangeField:
+			return consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+				switch {
+				case num == changeActionField && typ == protowire.VarintType:
+					out.Action = varint
+				case num == changeValuesField && typ == protowire.BytesType:
+					v, err := decodeDynamicValue(value)
+					if err != nil {
+						return err
+					}
+					out.Values = append(out.Values, v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// consumeFields calls the callback for each field of the protobuf message, unknown fields are skipped by the callback.
+func consumeFields(data []byte, callback func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := callback(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// actions converts the planproto action to the actions in the JSON plan, and splits the values into before and after.
+func (c tfplanResourceChange) actions() (tfjson.Actions, []byte, []byte, error) {
+	var before, after []byte
+	switch c.Action {
+	case protoActionCreate:
+		if len(c.Values) != 1 {
+			return nil, nil, nil, fmt.Errorf("expected 1 value for create, got %d", len(c.Values))
+		}
+		after = c.Values[0]
+	case protoActionNoop, protoActionDelete, protoActionForget:
+		if len(c.Values) != 1 {
+			return nil, nil, nil, fmt.Errorf("expected 1 value for action %d, got %d", c.Action, len(c.Values))
+		}
+		before = c.Values[0]
+		if c.Action == protoActionNoop {
+			after = c.Values[0]
+		}
+	default:
+		if len(c.Values) != 2 {
+			return nil, nil, nil, fmt.Errorf("expected 2 values for action %d, got %d", c.Action, len(c.Values))
+		}
+		before, after = c.Values[0], c.Values[1]
+	}
+
+	switch c.Action {
+	case protoActionNoop:
+		return tfjson.Actions{tfjson.ActionNoop}, before, after, nil
+	case protoActionCreate:
+		return tfjson.Actions{tfjson.ActionCreate}, before, after, nil
+	case protoActionRead:
+		return tfjson.Actions{tfjson.ActionRead}, before, after, nil
+	case protoActionUpdate:
+		return tfjson.Actions{tfjson.ActionUpdate}, before, after, nil
+	case protoActionDelete:
+		return tfjson.Actions{tfjson.ActionDelete}, before, after, nil
+	case protoActionDeleteThenCreate:
+		return tfjson.Actions{tfjson.ActionDelete, tfjson.ActionCreate}, before, after, nil
+	case protoActionCreateThenDelete:
+		return tfjson.Actions{tfjson.ActionCreate, tfjson.ActionDelete}, before, after, nil
+	case protoActionForget:
+		return tfjson.Actions{tfjson.ActionForget}, before, after, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported action %d", c.Action)
+}
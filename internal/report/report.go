@@ -0,0 +1,264 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Azure/aztfpreflight/internal/api"
+	"github.com/Azure/aztfpreflight/internal/types"
+)
+
+// SchemaVersion is the version of the result document. It's increased when a field is removed or its meaning changes.
+const SchemaVersion = "1"
+
+const (
+	// ExitCodeSuccess means all resources passed the preflight checks.
+	ExitCodeSuccess = 0
+	// ExitCodeToolError means the tool itself failed, for example the plan couldn't be read or none of the preflight requests could be sent.
+	ExitCodeToolError = 1
+	// ExitCodeValidationFindings means at least one resource failed the preflight or policy checks, or couldn't be validated.
+	ExitCodeValidationFindings = 2
+	// ExitCodePayloadFailures means the request payload of at least one resource couldn't be generated, and no other findings were reported.
+	ExitCodePayloadFailures = 3
+)
+
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+	StatusError   Status = "error"
+)
+
+// policyErrorCodes are the preflight error codes that are caused by Azure Policy.
+var policyErrorCodes = map[string]bool{
+	"RequestDisallowedByPolicy": true,
+}
+
+type Report struct {
+	SchemaVersion string            `json:"schemaVersion"`
+	ToolVersion   string            `json:"toolVersion"`
+	Error         string            `json:"error,omitempty"`
+	Summary       Summary           `json:"summary"`
+	Resources     []*ResourceResult `json:"resources"`
+
+	resourceMap map[string]*ResourceResult
+}
+
+type ResourceResult struct {
+	Address          string               `json:"address"`
+	ModuleAddress    string               `json:"moduleAddress,omitempty"`
+	Action           types.Action         `json:"action,omitempty"`
+	PayloadStatus    Status               `json:"payloadStatus"`
+	PayloadFailure   *types.FailedCase    `json:"payloadFailure,omitempty"`
+	PreflightStatus  Status               `json:"preflightStatus"`
+	PolicyStatus     Status               `json:"policyStatus"`
+	RequestError     string               `json:"requestError,omitempty"`
+	Errors           []api.PreflightError `json:"errors,omitempty"`
+	Warnings         []string             `json:"warnings,omitempty"`
+	Requests         []RequestResult      `json:"requests,omitempty"`
+	ThrottledRetries int                  `json:"throttledRetries,omitempty"`
+}
+
+// RequestResult describes a request sent for the resource, in the order of the requests.
+type RequestResult struct {
+	Sequence     int               `json:"sequence"`
+	Role         types.Role        `json:"role,omitempty"`
+	Method       string            `json:"method,omitempty"`
+	Kind         types.Kind        `json:"kind,omitempty"`
+	ResourceId   string            `json:"resourceId,omitempty"`
+	ResourceType string            `json:"resourceType,omitempty"`
+	APIVersion   string            `json:"apiVersion,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	// PreflightSkipReason is the reason why the request isn't validated by the preflight API, it's empty if it is.
+	PreflightSkipReason string `json:"preflightSkipReason,omitempty"`
+}
+
+type Summary struct {
+	Total              int `json:"total"`
+	PayloadSucceeded   int `json:"payloadSucceeded"`
+	PayloadFailed      int `json:"payloadFailed"`
+	PreflightPassed    int `json:"preflightPassed"`
+	PreflightFailed    int `json:"preflightFailed"`
+	PreflightSkipped   int `json:"preflightSkipped"`
+	PreflightErrored   int `json:"preflightErrored"`
+	PolicyFailed       int `json:"policyFailed"`
+	PreflightThrottled int `json:"preflightThrottled"`
+	// PreflightSkippedUpdates is the number of resources with a resource write that isn't validated by the preflight API because its body is partial,
+	// like a PATCH whose existing resource body isn't known or an azapi_update_resource. Their other requests may still be validated.
+	PreflightSkippedUpdates int `json:"preflightSkippedUpdates"`
+}
+
+func New(toolVersion string) *Report {
+	return &Report{
+		SchemaVersion: SchemaVersion,
+		ToolVersion:   toolVersion,
+		Resources:     make([]*ResourceResult, 0),
+		resourceMap:   make(map[string]*ResourceResult),
+	}
+}
+
+// AddPayloads records the payload generation result of the request models.
+// A resource that has several request models fails if any of them failed.
+func (r *Report) AddPayloads(models []types.RequestModel) {
+	for _, model := range models {
+		resource := r.resource(model.Address)
+		resource.ModuleAddress = model.ModuleAddress
+		resource.Action = model.Action
+		resource.Warnings = append(resource.Warnings, model.Warnings...)
+		if model.Failed != nil {
+			resource.PayloadStatus = StatusFailed
+			resource.PayloadFailure = model.Failed
+			resource.PreflightStatus = StatusSkipped
+			resource.PolicyStatus = StatusSkipped
+			continue
+		}
+		if resource.PayloadStatus == "" {
+			resource.PayloadStatus = StatusSuccess
+		}
+		resource.Requests = append(resource.Requests, RequestResult{
+			Sequence:            model.Sequence,
+			Role:                model.Role,
+			Method:              model.Method,
+			Kind:                model.Kind,
+			ResourceId:          model.ResourceId,
+			ResourceType:        model.ResourceType,
+			APIVersion:          model.APIVersion,
+			Headers:             model.Headers,
+			PreflightSkipReason: model.PreflightSkipReason(),
+		})
+	}
+}
+
+// AddPreflightResults records the preflight results of the resources whose payloads were generated.
+func (r *Report) AddPreflightResults(results []api.PreflightResult) {
+	for _, result := range results {
+		resource := r.resource(result.Address)
+		if resource.PayloadStatus == StatusFailed {
+			continue
+		}
+		if result.PayloadError != "" {
+			resource.PayloadStatus = StatusFailed
+			resource.PayloadFailure = &types.FailedCase{Detail: result.PayloadError}
+			resource.PreflightStatus = StatusSkipped
+			resource.PolicyStatus = StatusSkipped
+			continue
+		}
+		resource.Errors = append(resource.Errors, result.Errors...)
+		resource.ThrottledRetries += result.ThrottledRetries
+		if result.RequestError != "" {
+			resource.RequestError = result.RequestError
+		}
+		switch {
+		case resource.RequestError != "":
+			resource.PreflightStatus = StatusError
+			resource.PolicyStatus = StatusSkipped
+		case len(resource.Errors) != 0:
+			resource.PreflightStatus = StatusFailed
+			resource.PolicyStatus = StatusPassed
+			for _, preflightError := range resource.Errors {
+				if policyErrorCodes[preflightError.Code] {
+					resource.PolicyStatus = StatusFailed
+					break
+				}
+			}
+		default:
+			resource.PreflightStatus = StatusPassed
+			resource.PolicyStatus = StatusPassed
+		}
+	}
+}
+
+// SetError records an error that stopped the tool.
+func (r *Report) SetError(err error) {
+	r.Error = err.Error()
+}
+
+// Finalize marks the resources that were not preflighted as skipped and calculates the summary.
+func (r *Report) Finalize() {
+	r.Summary = Summary{
+		Total: len(r.Resources),
+	}
+	for _, resource := range r.Resources {
+		if resource.PreflightStatus == "" {
+			resource.PreflightStatus = StatusSkipped
+		}
+		if resource.PolicyStatus == "" {
+			resource.PolicyStatus = StatusSkipped
+		}
+
+		switch resource.PayloadStatus {
+		case StatusSuccess:
+			r.Summary.PayloadSucceeded++
+		case StatusFailed:
+			r.Summary.PayloadFailed++
+		}
+		switch resource.PreflightStatus {
+		case StatusPassed:
+			r.Summary.PreflightPassed++
+		case StatusFailed:
+			r.Summary.PreflightFailed++
+		case StatusSkipped:
+			r.Summary.PreflightSkipped++
+		case StatusError:
+			r.Summary.PreflightErrored++
+		}
+		if resource.PolicyStatus == StatusFailed {
+			r.Summary.PolicyFailed++
+		}
+		if resource.ThrottledRetries > 0 {
+			r.Summary.PreflightThrottled++
+		}
+		for _, request := range resource.Requests {
+			if request.Kind == types.KindARMWrite && request.PreflightSkipReason != "" {
+				r.Summary.PreflightSkippedUpdates++
+				break
+			}
+		}
+	}
+}
+
+// ExitCode returns the process exit code for the report. Tool errors take precedence over validation findings,
+// and validation findings take precedence over payload generation failures.
+// The preflight requests failing for every resource, like on an authentication failure, are a tool error,
+// while the resources whose requests failed among validated resources are reported as findings.
+func (r *Report) ExitCode() int {
+	preflighted := r.Summary.PreflightPassed + r.Summary.PreflightFailed + r.Summary.PreflightErrored
+	switch {
+	case r.Error != "" || (r.Summary.PreflightErrored != 0 && r.Summary.PreflightErrored == preflighted):
+		return ExitCodeToolError
+	case r.Summary.PreflightFailed != 0 || r.Summary.PolicyFailed != 0 || r.Summary.PreflightErrored != 0:
+		return ExitCodeValidationFindings
+	case r.Summary.PayloadFailed != 0:
+		return ExitCodePayloadFailures
+	}
+	return ExitCodeSuccess
+}
+
+// Write writes the report as JSON to the file, or to stdout if the file path is "-".
+func (r *Report) Write(filePath string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if filePath == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+func (r *Report) resource(address string) *ResourceResult {
+	if resource, ok := r.resourceMap[address]; ok {
+		return resource
+	}
+	resource := &ResourceResult{
+		Address: address,
+	}
+	r.resourceMap[address] = resource
+	r.Resources = append(r.Resources, resource)
+	return resource
+}
@@ -0,0 +1,126 @@
+package report_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/aztfpreflight/internal/api"
+	"github.com/Azure/aztfpreflight/internal/report"
+	"github.com/Azure/aztfpreflight/internal/types"
+)
+
+func Test_Report(t *testing.T) {
+	testcases := []struct {
+		Name             string
+		Models           []types.RequestModel
+		PreflightResults []api.PreflightResult
+		Error            error
+		ExpectSummary    report.Summary
+		ExpectExitCode   int
+	}{
+		{
+			Name: "all passed",
+			Models: []types.RequestModel{
+				{Address: "azurerm_resource_group.test", Action: types.ActionCreate},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_resource_group.test", Validated: true},
+			},
+			ExpectSummary:  report.Summary{Total: 1, PayloadSucceeded: 1, PreflightPassed: 1},
+			ExpectExitCode: report.ExitCodeSuccess,
+		},
+		{
+			Name: "payload failures",
+			Models: []types.RequestModel{
+				{Address: "azurerm_resource_group.test"},
+				{Address: "azurerm_storage_account.test", Failed: &types.FailedCase{Detail: "error"}},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_resource_group.test", Validated: true},
+			},
+			ExpectSummary:  report.Summary{Total: 2, PayloadSucceeded: 1, PayloadFailed: 1, PreflightPassed: 1, PreflightSkipped: 1},
+			ExpectExitCode: report.ExitCodePayloadFailures,
+		},
+		{
+			Name: "validation findings",
+			Models: []types.RequestModel{
+				{Address: "azurerm_network_security_group.test"},
+				{Address: "azurerm_storage_account.test", Failed: &types.FailedCase{Detail: "error"}},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_network_security_group.test", Errors: []api.PreflightError{{Code: "RequestDisallowedByPolicy"}}},
+			},
+			ExpectSummary:  report.Summary{Total: 2, PayloadSucceeded: 1, PayloadFailed: 1, PreflightFailed: 1, PreflightSkipped: 1, PolicyFailed: 1},
+			ExpectExitCode: report.ExitCodeValidationFindings,
+		},
+		{
+			Name: "request errors",
+			Models: []types.RequestModel{
+				{Address: "azurerm_resource_group.test"},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_resource_group.test", RequestError: "authentication failed"},
+			},
+			ExpectSummary:  report.Summary{Total: 1, PayloadSucceeded: 1, PreflightErrored: 1},
+			ExpectExitCode: report.ExitCodeToolError,
+		},
+		{
+			Name: "request errors of some resources",
+			Models: []types.RequestModel{
+				{Address: "azurerm_resource_group.test"},
+				{Address: "azurerm_storage_account.test"},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_resource_group.test", Validated: true},
+				{Address: "azurerm_storage_account.test", RequestError: "context deadline exceeded"},
+			},
+			ExpectSummary:  report.Summary{Total: 2, PayloadSucceeded: 2, PreflightPassed: 1, PreflightErrored: 1},
+			ExpectExitCode: report.ExitCodeValidationFindings,
+		},
+		{
+			Name: "preflight request body errors",
+			Models: []types.RequestModel{
+				{Address: "azurerm_resource_group.test"},
+				{Address: "azurerm_storage_account.test"},
+			},
+			PreflightResults: []api.PreflightResult{
+				{Address: "azurerm_resource_group.test", Validated: true},
+				{Address: "azurerm_storage_account.test", PayloadError: "invalid character 'x' looking for beginning of value"},
+			},
+			ExpectSummary:  report.Summary{Total: 2, PayloadSucceeded: 1, PayloadFailed: 1, PreflightPassed: 1, PreflightSkipped: 1},
+			ExpectExitCode: report.ExitCodePayloadFailures,
+		},
+		{
+			Name: "skipped updates",
+			Models: []types.RequestModel{
+				types.NewRequestModel("PATCH", "https://management.azure.com/subscriptions/000/resourceGroups/rg?api-version=2021-04-01", `{"tags":{}}`, nil),
+			},
+			ExpectSummary:  report.Summary{Total: 1, PayloadSucceeded: 1, PreflightSkipped: 1, PreflightSkippedUpdates: 1},
+			ExpectExitCode: report.ExitCodeSuccess,
+		},
+		{
+			Name:           "tool error",
+			Error:          errors.New("failed to show plan file"),
+			ExpectSummary:  report.Summary{},
+			ExpectExitCode: report.ExitCodeToolError,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			r := report.New("0.0.0")
+			r.AddPayloads(testcase.Models)
+			r.AddPreflightResults(testcase.PreflightResults)
+			if testcase.Error != nil {
+				r.SetError(testcase.Error)
+			}
+			r.Finalize()
+			if r.Summary != testcase.ExpectSummary {
+				t.Fatalf("Expected summary %+v, got %+v", testcase.ExpectSummary, r.Summary)
+			}
+			if code := r.ExitCode(); code != testcase.ExpectExitCode {
+				t.Fatalf("Expected exit code %d, got %d", testcase.ExpectExitCode, code)
+			}
+		})
+	}
+}
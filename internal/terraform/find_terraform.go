@@ -0,0 +1,31 @@
+package terraform
+
+import (
+	"context"
+	"os/exec"
+
+	install "github.com/hashicorp/hc-install"
+	"github.com/hashicorp/hc-install/fs"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/src"
+)
+
+// tofuBinaryName is the name of the OpenTofu executable, which is compatible with the terraform CLI.
+const tofuBinaryName = "tofu"
+
+// FindTerraform finds the path to the terraform executable, or the tofu executable if terraform is not installed.
+func FindTerraform(ctx context.Context) (string, error) {
+	i := install.NewInstaller()
+	execPath, err := i.Ensure(ctx, []src.Source{
+		&fs.AnyVersion{
+			Product: &product.Terraform,
+		},
+	})
+	if err == nil {
+		return execPath, nil
+	}
+	if tofuPath, tofuErr := exec.LookPath(tofuBinaryName); tofuErr == nil {
+		return tofuPath, nil
+	}
+	return "", err
+}
@@ -6,22 +6,52 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/aztfpreflight/internal/account"
+	"github.com/Azure/aztfpreflight/internal/types"
+	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
 	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-provider-azurerm/helpers"
+	"github.com/ms-henglu/azurerm-interceptor/interceptor"
 	"github.com/sirupsen/logrus"
 )
 
 type TerraformClient struct {
 	v5Client        tfprotov5.ProviderServer
 	ResourceSchemas map[string]*tfprotov5.Schema
+	// scope is the interceptor cache scope of the client, it's also configured as the partner ID of the provider,
+	// so the requests sent by the provider are cached in the scope even if they don't carry the apply context.
+	scope string
 }
 
+// credentialKeys are the provider arguments which configure the authentication, the embedded provider always uses the fake credentials.
+var credentialKeys = []string{
+	"client_certificate", "client_certificate_password", "client_certificate_path", "client_id_file_path", "client_secret_file_path",
+	"oidc_request_token", "oidc_request_url", "oidc_token", "oidc_token_file_path", "ado_pipeline_service_connection_id",
+	"use_aks_workload_identity", "use_msi", "msi_endpoint", "use_oidc", "auxiliary_tenant_ids",
+}
+
+// ignoredPartnerIds are the partner IDs of the provider configurations which were replaced, they're only warned about once.
+var ignoredPartnerIds sync.Map
+
+// NewTerraformClient configures a new embedded azurerm provider. Each client has its own interceptor cache scope,
+// so the resources applied by different clients concurrently can't see each other's request bodies.
 func NewTerraformClient() *TerraformClient {
+	return NewTerraformClientWithConfig(nil)
+}
+
+// NewTerraformClientWithConfig configures a new embedded azurerm provider with the arguments of a `provider "azurerm"` block,
+// like `subscription_id`, `features` and `storage_use_azuread`. The nested blocks are lists of objects, like `"features": [{}]`.
+// The authentication arguments are replaced with fake credentials, and the subscription of the default account is used if it's not set.
+// The `partner_id` is replaced with the interceptor cache scope of the client, a configured partner ID is ignored with a warning.
+func NewTerraformClientWithConfig(config map[string]interface{}) *TerraformClient {
 	os.Setenv("ARM_PROVIDER_ENHANCED_VALIDATION", "false")
 	os.Setenv("ARM_SKIP_PROVIDER_REGISTRATION", "true")
 	v5Client, err := helpers.ProtoV5Provider()
@@ -31,9 +61,9 @@ func NewTerraformClient() *TerraformClient {
 
 	ctx := context.TODO()
 	// Disable logging for the provider
-	log.SetOutput(io.Discard)
+	restoreLogs := DisableProviderLogs()
 	providerSchemaResponse, err := v5Client.GetProviderSchema(ctx, nil)
-	log.SetOutput(os.Stdout)
+	restoreLogs()
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -44,19 +74,36 @@ func NewTerraformClient() *TerraformClient {
 		subscriptionId = v
 	}
 
-	providerCfg := fmt.Sprintf(`
-{
-  "features": [{}],
-  "use_cli" : false,
-  "subscription_id" : "%s",
-  "tenant_id"       : "00000000-0000-0000-0000-000000000000",
-  "client_id"       : "00000000-0000-0000-0000-000000000000",
-  "client_secret"   : "00000000-0000-0000-0000-000000000000"
-}
-`, subscriptionId)
+	scope := uuid.New().String()
+	cfg := map[string]interface{}{
+		"features":        []interface{}{map[string]interface{}{}},
+		"subscription_id": subscriptionId,
+	}
+	for key, value := range config {
+		if value != nil {
+			cfg[key] = value
+		}
+	}
+	for _, key := range credentialKeys {
+		delete(cfg, key)
+	}
+	cfg["use_cli"] = false
+	cfg["tenant_id"] = "00000000-0000-0000-0000-000000000000"
+	cfg["client_id"] = "00000000-0000-0000-0000-000000000000"
+	cfg["client_secret"] = "00000000-0000-0000-0000-000000000000"
+	if partnerId, ok := cfg["partner_id"].(string); ok && partnerId != "" {
+		if _, warned := ignoredPartnerIds.LoadOrStore(partnerId, true); !warned {
+			logrus.Warnf("the partner_id %q of the azurerm provider configuration is ignored, the user agent of the generated requests doesn't contain it", partnerId)
+		}
+	}
+	cfg["partner_id"] = scope
+	providerCfg, err := json.Marshal(cfg)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
 	providerConfigType := providerSchemaResponse.Provider.Block.ValueType()
-	providerConfigVal, err := tftypes.ValueFromJSONWithOpts([]byte(providerCfg), providerConfigType, tftypes.ValueFromJSONOpts{})
+	providerConfigVal, err := tftypes.ValueFromJSONWithOpts(providerCfg, providerConfigType, tftypes.ValueFromJSONOpts{IgnoreUndefinedAttributes: true})
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -66,21 +113,100 @@ func NewTerraformClient() *TerraformClient {
 	}
 
 	// disable logging for the provider
-	log.SetOutput(io.Discard)
+	restoreLogs = DisableProviderLogs()
 	_, err = v5Client.ConfigureProvider(ctx, &tfprotov5.ConfigureProviderRequest{
 		Config: &providerConfig,
 	})
-	log.SetOutput(os.Stdout)
+	restoreLogs()
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	return &TerraformClient{
 		v5Client:        v5Client,
 		ResourceSchemas: providerSchemaResponse.ResourceSchemas,
+		scope:           scope,
+	}
+}
+
+// DisableProviderLogs discards the logs of the embedded providers, and returns a function to restore the previous log output.
+// The log output is global, so it's disabled once around all the concurrent applies instead of per apply.
+func DisableProviderLogs() func() {
+	previous := log.Writer()
+	log.SetOutput(io.Discard)
+	return func() {
+		log.SetOutput(previous)
 	}
 }
 
-func (client *TerraformClient) ApplyResource(resourceType string, input interface{}) error {
+// SetSessionResource stores the synthetic ARM resource of the resource ID, it's returned for the GET requests of all clients
+// whose own interceptor cache doesn't have the resource.
+func SetSessionResource(resourceId string, body string) {
+	interceptor.SetSessionResource(resourceId, body)
+}
+
+// ResetSession removes the synthetic ARM resources of the previous session.
+func ResetSession() {
+	interceptor.ResetSession()
+}
+
+// ApplyResource applies the planned value of the resource with the embedded provider, and returns the request models of the intercepted requests.
+// The requests are read from the interceptor recorder of the apply, the requests in the returned error are only parsed if nothing was recorded.
+// When the prior state isn't nil, the resource is updated: the prior state is applied first to seed the interceptor cache,
+// so the GET requests of the update are answered with the request bodies of the existing resource, and the update sends the real delta.
+// The partial bodies of the PATCH requests of the update are merged onto the bodies of the existing resource, see types.RequestModel.MergedBody.
+func (client *TerraformClient) ApplyResource(resourceType string, prior interface{}, input interface{}) ([]types.RequestModel, error) {
+	// the request bodies of the previous apply must not be returned for the GET requests of this one
+	interceptor.ResetScope(client.scope)
+	// existingBodies are the request bodies of the existing resource, keyed by the lowercased URL path
+	existingBodies := make(map[string]string)
+	if prior != nil {
+		priorRecorder := interceptor.NewRecorder(client.scope)
+		if err := client.applyResourceChange(interceptor.WithRecorder(context.TODO(), priorRecorder), resourceType, nil, prior); err != nil {
+			logrus.Debugf("applied the prior state of %s: %v", resourceType, err)
+		}
+		priorRecorder.Close()
+		for _, request := range priorRecorder.Requests() {
+			if request.Method == http.MethodPut {
+				existingBodies[requestPathKey(request.URL)] = request.Body
+			}
+		}
+	}
+
+	recorder := interceptor.NewRecorder(client.scope)
+	defer recorder.Close()
+	err := client.applyResourceChange(interceptor.WithRecorder(context.TODO(), recorder), resourceType, prior, input)
+
+	requests := recorder.Requests()
+	if len(requests) == 0 {
+		if err == nil {
+			return nil, nil
+		}
+		return types.NewRequestModelsFromError(err.Error()), err
+	}
+	out := make([]types.RequestModel, 0, len(requests))
+	for _, request := range requests {
+		model := types.NewRequestModel(request.Method, request.URL, request.Body, request.Headers)
+		if existing, ok := existingBodies[requestPathKey(request.URL)]; ok && request.Method == http.MethodPatch {
+			if merged, err := types.MergePatch(existing, request.Body); err == nil {
+				model.MergedBody = merged
+			} else {
+				logrus.Debugf("failed to merge the PATCH body of %s onto the existing resource: %v", request.URL, err)
+			}
+		}
+		out = append(out, model)
+	}
+	return out, err
+}
+
+func requestPathKey(requestUrl string) string {
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return strings.ToLower(requestUrl)
+	}
+	return strings.ToLower(strings.TrimSuffix(parsedUrl.Path, "/"))
+}
+
+func (client *TerraformClient) applyResourceChange(ctx context.Context, resourceType string, prior interface{}, input interface{}) error {
 	convertedJson, _ := json.Marshal(input)
 
 	schemaType := client.ValueType(resourceType)
@@ -88,7 +214,8 @@ func (client *TerraformClient) ApplyResource(resourceType string, input interfac
 		return fmt.Errorf("resource type %s not found", resourceType)
 	}
 
-	plannedState, err := tftypes.ValueFromJSONWithOpts(convertedJson, schemaType, tftypes.ValueFromJSONOpts{})
+	// the prior state applied as the planned state may have been written by an older schema version
+	plannedState, err := tftypes.ValueFromJSONWithOpts(convertedJson, schemaType, tftypes.ValueFromJSONOpts{IgnoreUndefinedAttributes: true})
 	if err != nil {
 		logrus.Debugf("failed to convert json to value: %v", err)
 		return err
@@ -98,18 +225,24 @@ func (client *TerraformClient) ApplyResource(resourceType string, input interfac
 		logrus.Debugf("failed to convert value to dynamic value: %v", err)
 		return err
 	}
-	priorState, err := tfprotov5.NewDynamicValue(schemaType, tftypes.NewValue(schemaType, nil))
+	priorStateValue := tftypes.NewValue(schemaType, nil)
+	if prior != nil {
+		priorJson, _ := json.Marshal(prior)
+		priorStateValue, err = tftypes.ValueFromJSONWithOpts(priorJson, schemaType, tftypes.ValueFromJSONOpts{IgnoreUndefinedAttributes: true})
+		if err != nil {
+			logrus.Debugf("failed to convert prior state json to value: %v", err)
+			return err
+		}
+	}
+	priorState, err := tfprotov5.NewDynamicValue(schemaType, priorStateValue)
 	if err != nil {
 		logrus.Debugf("failed to create prior state: %v", err)
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	ctx, cancel := context.WithTimeout(interceptor.WithScope(ctx, client.scope), time.Second*5)
 	defer cancel()
 
-	// disable logging for the provider
-	log.SetOutput(io.Discard)
-
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Debugf("recovered from panic: %v", r)
@@ -123,7 +256,6 @@ func (client *TerraformClient) ApplyResource(resourceType string, input interfac
 		PlannedPrivate: nil,
 		ProviderMeta:   nil,
 	})
-	log.SetOutput(os.Stdout)
 	if err != nil {
 		logrus.Debugf("failed to apply resource change: %v", err)
 		return err
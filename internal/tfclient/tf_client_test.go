@@ -1,6 +1,9 @@
 package tfclient_test
 
 import (
+	"bytes"
+	"log"
+	"net/http"
 	"strings"
 	"testing"
 
@@ -17,11 +20,34 @@ func Test_NewTerraformClient(t *testing.T) {
 	}
 }
 
+func Test_NewTerraformClientWithConfig(t *testing.T) {
+	client := tfclient.NewTerraformClientWithConfig(map[string]interface{}{
+		"subscription_id":     "11111111-1111-1111-1111-111111111111",
+		"storage_use_azuread": true,
+		"use_msi":             true,
+		"features": []interface{}{
+			map[string]interface{}{
+				"resource_group": []interface{}{
+					map[string]interface{}{"prevent_deletion_if_contains_resources": false},
+				},
+			},
+		},
+	})
+	if client == nil {
+		t.Fatal("Expected non-nil client")
+	}
+	if client.ValueType("azurerm_resource_group") == nil {
+		t.Fatal("Expected the schema of azurerm_resource_group")
+	}
+}
+
 func Test_ApplyResource(t *testing.T) {
 	testcases := []struct {
 		resourceType string
 		body         interface{}
 		expectError  string
+		// expectResourceType is the ARM resource type of the recorded request
+		expectResourceType string
 	}{
 		{
 			resourceType: "azurerm_automation_account",
@@ -31,7 +57,8 @@ func Test_ApplyResource(t *testing.T) {
 				"resource_group_name": "test",
 				"sku_name":            "Basic",
 			},
-			expectError: "unexpected status 400 with response:",
+			expectError:        "unexpected status 400 with response:",
+			expectResourceType: "Microsoft.Automation/automationAccounts",
 		},
 		{
 			resourceType: "azurerm_resource_group",
@@ -39,18 +66,97 @@ func Test_ApplyResource(t *testing.T) {
 				"name":     "test",
 				"location": "East US",
 			},
-			expectError: `Status=400 Code="InterceptedError"`,
+			expectError:        `Status=400 Code="InterceptedError"`,
+			expectResourceType: "Microsoft.Resources/resourceGroups",
 		},
 	}
 
 	client := tfclient.NewTerraformClient()
 	for _, tc := range testcases {
-		err := client.ApplyResource(tc.resourceType, tc.body)
+		models, err := client.ApplyResource(tc.resourceType, nil, tc.body)
 		if err == nil {
 			t.Fatalf("Expected error for resource type %s, got nil", tc.resourceType)
 		}
 		if tc.expectError != "" && !strings.Contains(err.Error(), tc.expectError) {
 			t.Fatalf("Expected error to contain %q, got %q", tc.expectError, err.Error())
 		}
+		if len(models) == 0 || models[0].Method != http.MethodPut || models[0].ResourceType != tc.expectResourceType {
+			t.Fatalf("Expected the recorded PUT request of %s, got %v", tc.expectResourceType, models)
+		}
+	}
+}
+
+func Test_ApplyResource_Update(t *testing.T) {
+	id := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test"
+	prior := map[string]interface{}{
+		"id":       id,
+		"name":     "test",
+		"location": "eastus",
+		"tags":     map[string]interface{}{"env": "dev"},
+	}
+	input := map[string]interface{}{
+		"id":       id,
+		"name":     "test",
+		"location": "eastus",
+		"tags":     map[string]interface{}{"env": "prod"},
+	}
+
+	client := tfclient.NewTerraformClient()
+	models, err := client.ApplyResource("azurerm_resource_group", prior, input)
+	if err == nil {
+		t.Fatal("Expected the intercepted error, got nil")
+	}
+	if len(models) != 1 || models[0].Method != http.MethodPut || !strings.EqualFold(models[0].ResourceId, id) {
+		t.Fatalf("Expected the recorded PUT request of %s, got %v", id, models)
+	}
+	if body := models[0].Body; !strings.Contains(body, `"env":"prod"`) || !strings.Contains(body, `"location":"eastus"`) {
+		t.Fatalf("Expected the updated tags and the location in the request body, got %s", body)
+	}
+}
+
+func Test_ApplyResource_UpdatePatch(t *testing.T) {
+	id := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/test/providers/Microsoft.Network/publicIPPrefixes/test"
+	prior := map[string]interface{}{
+		"id":                  id,
+		"name":                "test",
+		"resource_group_name": "test",
+		"location":            "eastus",
+		"sku":                 "Standard",
+		"sku_tier":            "Regional",
+		"prefix_length":       28,
+		"ip_version":          "IPv4",
+		"tags":                map[string]interface{}{"env": "dev"},
+	}
+	input := make(map[string]interface{})
+	for key, value := range prior {
+		input[key] = value
+	}
+	input["tags"] = map[string]interface{}{"env": "prod"}
+
+	client := tfclient.NewTerraformClient()
+	models, _ := client.ApplyResource("azurerm_public_ip_prefix", prior, input)
+	if len(models) != 1 || models[0].Method != http.MethodPatch {
+		t.Fatalf("Expected the recorded PATCH request of %s, got %v", id, models)
+	}
+	if reason := models[0].PreflightSkipReason(); reason != "" {
+		t.Fatalf("Expected the PATCH to be preflighted with its merged body, got skip reason %q", reason)
+	}
+	if body := models[0].MergedBody; !strings.Contains(body, `"env":"prod"`) || !strings.Contains(body, `"location":"eastus"`) || !strings.Contains(body, `"prefixLength":28`) {
+		t.Fatalf("Expected the updated tags merged onto the existing resource, got %s", body)
+	}
+}
+
+func Test_DisableProviderLogs(t *testing.T) {
+	var buffer bytes.Buffer
+	previous := log.Writer()
+	defer log.SetOutput(previous)
+	log.SetOutput(&buffer)
+
+	restore := tfclient.DisableProviderLogs()
+	log.Print("discarded")
+	restore()
+	log.Print("restored")
+	if output := buffer.String(); strings.Contains(output, "discarded") || !strings.Contains(output, "restored") {
+		t.Fatalf("Expected only the logs after the restore, got %q", output)
 	}
 }
@@ -2,8 +2,13 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/go-autorest/autorest/azure"
 )
 
@@ -26,16 +31,241 @@ func NewRequestModelsFromError(input string) []RequestModel {
 	return nil
 }
 
+// Action is the kind of change Terraform plans to make to a resource.
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionReplace Action = "replace"
+)
+
+// Role is the role of a request among the requests sent for a resource.
+type Role string
+
+const (
+	// RolePrimary is the request which creates or updates the resource itself.
+	RolePrimary Role = "primary"
+	// RoleChild is a request which creates or updates another resource, like a child resource managed by the same terraform resource.
+	RoleChild Role = "child"
+	// RoleAction is a follow-up request of the resource, like a POST action or another update of the same resource.
+	RoleAction Role = "action"
+)
+
+// Kind is the kind of the endpoint and the operation of a request.
+type Kind string
+
+const (
+	// KindARMWrite is a PUT or PATCH request to Azure Resource Manager, which creates or updates a resource.
+	KindARMWrite Kind = "armWrite"
+	// KindARMAction is a POST request to Azure Resource Manager, like `.../listKeys`.
+	KindARMAction Kind = "armAction"
+	// KindDataPlane is a request to the data plane endpoint of a service, like `*.vault.azure.net` or `*.blob.core.windows.net`.
+	KindDataPlane Kind = "dataPlane"
+	// KindOther is a request to any other endpoint, like Microsoft Graph.
+	KindOther Kind = "other"
+)
+
+// resourceManagerHosts are the Azure Resource Manager endpoints of the public and the sovereign clouds.
+var resourceManagerHosts = map[string]bool{
+	"management.azure.com":         true,
+	"management.chinacloudapi.cn":  true,
+	"management.usgovcloudapi.net": true,
+}
+
+// dataPlaneHostSuffixes are the host suffixes of the data plane endpoints of the services.
+var dataPlaneHostSuffixes = []string{
+	".vault.azure.net", ".vault.azure.cn", ".vault.usgovcloudapi.net",
+	".managedhsm.azure.net",
+	".core.windows.net", ".core.chinacloudapi.cn", ".core.usgovcloudapi.net",
+	".azurecr.io", ".azurecr.cn", ".azurecr.us",
+	".documents.azure.com", ".search.windows.net", ".servicebus.windows.net", ".azconfig.io",
+}
+
 type RequestModel struct {
-	URL     string `json:"url"`
-	Body    string `json:"body"`
-	Address string `json:"address"`
-	Failed  *FailedCase
+	// Method is the HTTP method of the request, like PUT or PATCH, it's empty if it's unknown and the request is a PUT.
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url"`
+	Kind   Kind   `json:"kind,omitempty"`
+	// APIVersion, ResourceId and ResourceType are parsed from the URL. The resource of a POST action is the resource the action is called on.
+	APIVersion   string `json:"apiVersion,omitempty"`
+	ResourceId   string `json:"resourceId,omitempty"`
+	ResourceType string `json:"resourceType,omitempty"`
+	// Headers are the conditional and the ARM specific headers of the request, like If-Match and x-ms-*.
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+	// Partial is true when the body only holds the properties to update, like the body of an azapi_update_resource,
+	// which the provider merges into the existing resource before sending it.
+	Partial bool `json:"partial,omitempty"`
+	// MergedBody is the partial body of a PATCH or of a partial request merged onto the body of the existing resource,
+	// it's validated by the preflight API instead of the body. It's empty if the body of the existing resource isn't known.
+	MergedBody string `json:"mergedBody,omitempty"`
+	// Sequence is the order of the request among the requests of the resource, starting from 1.
+	Sequence      int         `json:"sequence,omitempty"`
+	Role          Role        `json:"role,omitempty"`
+	Address       string      `json:"address"`
+	ModuleAddress string      `json:"moduleAddress,omitempty"`
+	Action        Action      `json:"action,omitempty"`
+	Warnings      []string    `json:"warnings,omitempty"`
+	Failed        *FailedCase `json:"failed,omitempty"`
+}
+
+// NewRequestModel returns the request model of the request, the API version and the ARM resource of the URL are parsed.
+func NewRequestModel(method string, requestUrl string, body string, headers map[string]string) RequestModel {
+	out := RequestModel{
+		Method:  method,
+		URL:     requestUrl,
+		Body:    body,
+		Headers: headers,
+	}
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return out
+	}
+	out.Kind = requestKind(method, parsedUrl)
+	if out.Kind != KindARMWrite && out.Kind != KindARMAction {
+		return out
+	}
+	out.APIVersion = parsedUrl.Query().Get("api-version")
+	armId, err := arm.ParseResourceID(strings.TrimSuffix(parsedUrl.Path, "/"))
+	if err != nil {
+		return out
+	}
+	// the last segment of an action URL is the action, like `.../vaults/kv/listKeys`
+	if armId.Name == "" && armId.Parent != nil {
+		armId = armId.Parent
+	}
+	out.ResourceId = armId.String()
+	out.ResourceType = armId.ResourceType.String()
+	return out
+}
+
+func requestKind(method string, parsedUrl *url.URL) Kind {
+	host := strings.ToLower(parsedUrl.Hostname())
+	if resourceManagerHosts[host] {
+		if method == http.MethodPost {
+			return KindARMAction
+		}
+		return KindARMWrite
+	}
+	for _, suffix := range dataPlaneHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return KindDataPlane
+		}
+	}
+	return KindOther
+}
+
+// SetRequestRoles numbers the requests of a resource in the order they're sent and sets their roles.
+// The first ARM write is the primary request, the other ARM writes to the same resource are actions and those to other resources are children.
+// The ARM actions, the data plane requests and the requests to other endpoints are actions.
+func SetRequestRoles(models []RequestModel) {
+	primary := -1
+	for i := range models {
+		if models[i].Kind == KindARMWrite {
+			primary = i
+			break
+		}
+	}
+	for i := range models {
+		models[i].Sequence = i + 1
+		switch {
+		case i == primary:
+			models[i].Role = RolePrimary
+		case models[i].Kind != KindARMWrite:
+			models[i].Role = RoleAction
+		case primary != -1 && strings.EqualFold(models[i].ResourceId, models[primary].ResourceId):
+			models[i].Role = RoleAction
+		default:
+			models[i].Role = RoleChild
+		}
+	}
+}
+
+// PreflightSkipReason returns the reason why the request can't be validated by the preflight API, it's empty if it can.
+// The preflight API validates the full resource bodies of the ARM PUT requests, and the partial bodies merged onto the existing resources.
+func (m RequestModel) PreflightSkipReason() string {
+	switch m.Kind {
+	case KindARMAction:
+		return "the request is an ARM action, the preflight API only validates resource writes"
+	case KindDataPlane:
+		return fmt.Sprintf("the request is sent to the data plane endpoint %s, the preflight API only validates ARM requests", requestHost(m.URL))
+	case KindOther:
+		return fmt.Sprintf("the request is sent to %s, which isn't an ARM endpoint", requestHost(m.URL))
+	}
+	if m.MergedBody != "" {
+		return ""
+	}
+	if m.Method == http.MethodPatch {
+		return "the request is a PATCH with a partial body, the preflight API only validates full resource bodies"
+	}
+	if m.Partial {
+		return "the request body only holds the updated properties, the preflight API only validates full resource bodies"
+	}
+	return ""
+}
+
+// IsSkippedUpdate reports whether the request is a resource write which isn't validated by the preflight API
+// because its body is partial, like a PATCH whose existing resource body isn't known.
+func (m RequestModel) IsSkippedUpdate() bool {
+	return m.Kind == KindARMWrite && m.PreflightSkipReason() != ""
+}
+
+// PreflightBody returns the body validated by the preflight API, the merged body if the request body is partial.
+func (m RequestModel) PreflightBody() string {
+	if m.MergedBody != "" {
+		return m.MergedBody
+	}
+	return m.Body
+}
+
+// MergePatch applies the JSON merge patch (RFC 7386) of a partial request body to the body of the existing resource:
+// the objects are merged recursively, the null values remove the properties, and the other values replace them.
+func MergePatch(target string, patch string) (string, error) {
+	var targetValue, patchValue interface{}
+	if err := json.Unmarshal([]byte(target), &targetValue); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(patch), &patchValue); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(mergePatch(targetValue, patchValue))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+func requestHost(requestUrl string) string {
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return requestUrl
+	}
+	return parsedUrl.Host
 }
 
 type FailedCase struct {
-	TestcasePath string
-	Detail       string
+	TestcasePath string `json:"testcasePath,omitempty"`
+	Detail       string `json:"detail"`
 }
 
 type ErrorParser interface {
@@ -72,10 +302,8 @@ func (p AutoRestErrorParser) ParseError(input string) []RequestModel {
 
 		url := serverError.InnerError["url"].(string)
 		bodyJson := serverError.InnerError["body"].(string)
-		model := RequestModel{
-			URL:  url,
-			Body: bodyJson,
-		}
+		method, _ := serverError.InnerError["method"].(string)
+		model := NewRequestModel(method, url, bodyJson, innerErrorHeaders(serverError.InnerError))
 		result = append(result, model)
 	}
 	return result
@@ -121,11 +349,23 @@ func (p AutoRestPollerErrorParser) ParseError(input string) []RequestModel {
 		if bodyVal, ok := innerError["body"]; ok {
 			bodyJson = bodyVal.(string)
 		}
-		model := RequestModel{
-			URL:  url,
-			Body: bodyJson,
-		}
+		method, _ := innerError["method"].(string)
+		model := NewRequestModel(method, url, bodyJson, innerErrorHeaders(innerError))
 		result = append(result, model)
 	}
 	return result
 }
+
+func innerErrorHeaders(innerError map[string]interface{}) map[string]string {
+	headers, ok := innerError["headers"].(map[string]interface{})
+	if !ok || len(headers) == 0 {
+		return nil
+	}
+	out := make(map[string]string)
+	for key, value := range headers {
+		if v, ok := value.(string); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
@@ -33,6 +33,17 @@ func Test_NewRequestModelsFromError(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: `				updating Resource Group "test": resources.GroupsClient#Update: Failure responding to request: StatusCode=400 -- Original Error: autorest/azure: Service returned an error. Status=400 Code="InterceptedError" Message="InterceptedError" InnerError={"body":"{\"tags\":{\"env\":\"prod\"}}","headers":{"If-Match":"*"},"method":"PATCH","url":"https://management.azure.com/subscriptions/0b1f6471-1bf0-4dda-aec3-cb9272f09590/resourcegroups/test?api-version=2020-06-01"}`,
+			expect: []types.RequestModel{
+				{
+					Method:  "PATCH",
+					Headers: map[string]string{"If-Match": "*"},
+					URL:     "https://management.azure.com/subscriptions/0b1f6471-1bf0-4dda-aec3-cb9272f09590/resourcegroups/test?api-version=2020-06-01",
+					Body:    "{\"tags\":{\"env\":\"prod\"}}",
+				},
+			},
+		},
 	}
 
 	for _, tc := range testcases {
@@ -44,6 +55,12 @@ func Test_NewRequestModelsFromError(t *testing.T) {
 			if r.URL != tc.expect[i].URL {
 				t.Fatalf("Expected URL %s, got %s", tc.expect[i].URL, r.URL)
 			}
+			if r.Method != tc.expect[i].Method {
+				t.Fatalf("Expected method %q, got %q", tc.expect[i].Method, r.Method)
+			}
+			if !reflect.DeepEqual(r.Headers, tc.expect[i].Headers) {
+				t.Fatalf("Expected headers %v, got %v", tc.expect[i].Headers, r.Headers)
+			}
 
 			var actualBody, expectBody interface{}
 			err := json.Unmarshal([]byte(r.Body), &actualBody)
@@ -60,3 +77,137 @@ func Test_NewRequestModelsFromError(t *testing.T) {
 		}
 	}
 }
+
+func Test_NewRequestModel(t *testing.T) {
+	testcases := []struct {
+		method       string
+		url          string
+		kind         types.Kind
+		resourceId   string
+		resourceType string
+		apiVersion   string
+		partial      bool
+		mergedBody   string
+		preflighted  bool
+	}{
+		{
+			method:       "PUT",
+			url:          "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet?api-version=2023-04-01",
+			kind:         types.KindARMWrite,
+			resourceId:   "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet",
+			resourceType: "Microsoft.Network/virtualNetworks/subnets",
+			apiVersion:   "2023-04-01",
+			preflighted:  true,
+		},
+		{
+			method:       "POST",
+			url:          "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa/listKeys?api-version=2023-01-01",
+			kind:         types.KindARMAction,
+			resourceId:   "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa",
+			resourceType: "Microsoft.Storage/storageAccounts",
+			apiVersion:   "2023-01-01",
+		},
+		{
+			method:       "PUT",
+			url:          "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			kind:         types.KindARMWrite,
+			resourceId:   "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			resourceType: "Microsoft.Network/virtualNetworks",
+			apiVersion:   "2023-04-01",
+			partial:      true,
+		},
+		{
+			method:       "PATCH",
+			url:          "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			kind:         types.KindARMWrite,
+			resourceId:   "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			resourceType: "Microsoft.Network/virtualNetworks",
+			apiVersion:   "2023-04-01",
+		},
+		{
+			method:       "PATCH",
+			url:          "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet?api-version=2023-04-01",
+			kind:         types.KindARMWrite,
+			resourceId:   "/subscriptions/000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			resourceType: "Microsoft.Network/virtualNetworks",
+			apiVersion:   "2023-04-01",
+			mergedBody:   `{"location":"westeurope","tags":{"env":"prod"}}`,
+			preflighted:  true,
+		},
+		{
+			method:      "PUT",
+			url:         "https://management.azure.com/invalid",
+			kind:        types.KindARMWrite,
+			preflighted: true,
+		},
+		{
+			method: "PUT",
+			url:    "https://kv.vault.azure.net/secrets/secret?api-version=7.4",
+			kind:   types.KindDataPlane,
+		},
+		{
+			method: "PUT",
+			url:    "https://sa.blob.core.windows.net/container/blob",
+			kind:   types.KindDataPlane,
+		},
+		{
+			method: "POST",
+			url:    "https://graph.microsoft.com/v1.0/applications",
+			kind:   types.KindOther,
+		},
+	}
+
+	for _, tc := range testcases {
+		model := types.NewRequestModel(tc.method, tc.url, "{}", nil)
+		model.Partial = tc.partial
+		model.MergedBody = tc.mergedBody
+		if model.Kind != tc.kind || model.ResourceId != tc.resourceId || model.ResourceType != tc.resourceType || model.APIVersion != tc.apiVersion {
+			t.Fatalf("Expected %q, %q, %q, %q, got %q, %q, %q, %q", tc.kind, tc.resourceId, tc.resourceType, tc.apiVersion, model.Kind, model.ResourceId, model.ResourceType, model.APIVersion)
+		}
+		if reason := model.PreflightSkipReason(); (reason == "") != tc.preflighted {
+			t.Fatalf("Expected %s to be preflighted: %v, got skip reason %q", tc.url, tc.preflighted, reason)
+		}
+	}
+}
+
+func Test_MergePatch(t *testing.T) {
+	target := `{"location":"westeurope","tags":{"env":"dev","owner":"a"},"properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]},"dhcpOptions":{"dnsServers":["10.0.0.4"]}}}`
+	patch := `{"tags":{"env":"prod","owner":null},"properties":{"dhcpOptions":{"dnsServers":[]}}}`
+	actual, err := types.MergePatch(target, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actualValue, expectValue interface{}
+	if err := json.Unmarshal([]byte(actual), &actualValue); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"location":"westeurope","tags":{"env":"prod"},"properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]},"dhcpOptions":{"dnsServers":[]}}}`
+	if err := json.Unmarshal([]byte(expect), &expectValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actualValue, expectValue) {
+		t.Fatalf("Expected %s, got %s", expect, actual)
+	}
+
+	if _, err := types.MergePatch(target, `{"tags":`); err == nil {
+		t.Fatalf("Expected an error for an invalid patch")
+	}
+}
+
+func Test_SetRequestRoles(t *testing.T) {
+	accountId := "https://management.azure.com/subscriptions/000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"
+	models := []types.RequestModel{
+		types.NewRequestModel("POST", accountId+"/listKeys?api-version=2023-01-01", "", nil),
+		types.NewRequestModel("PUT", accountId+"?api-version=2023-01-01", "{}", map[string]string{"If-None-Match": "*"}),
+		types.NewRequestModel("PUT", accountId+"/blobServices/default?api-version=2023-01-01", "{}", nil),
+		types.NewRequestModel("PATCH", accountId+"?api-version=2023-01-01", "{}", nil),
+	}
+	types.SetRequestRoles(models)
+
+	expect := []types.Role{types.RoleAction, types.RolePrimary, types.RoleChild, types.RoleAction}
+	for i, model := range models {
+		if model.Sequence != i+1 || model.Role != expect[i] {
+			t.Fatalf("Expected request %d to be %s, got request %d to be %s", i+1, expect[i], model.Sequence, model.Role)
+		}
+	}
+}
@@ -2,38 +2,59 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"path"
+	"os"
+	"strings"
 
 	"github.com/Azure/aztfpreflight/internal/api"
 	"github.com/Azure/aztfpreflight/internal/plan"
-	"github.com/Azure/aztfpreflight/internal/tfclient"
+	"github.com/Azure/aztfpreflight/internal/planfile"
+	"github.com/Azure/aztfpreflight/internal/report"
 	"github.com/Azure/aztfpreflight/internal/types"
 	"github.com/Azure/aztfpreflight/internal/utils"
-	"github.com/hashicorp/terraform-exec/tfexec"
 	"github.com/sirupsen/logrus"
 )
 
 const helpMessage = `
 Usage: aztfpreflight [options]
 Options:
-	-i <file>   		file path to terraform plan file
+	-i <file>   		file path to terraform plan file, or the output of "terraform show -json", "-" reads from stdin
 	-v          		enable verbose logging
 	-h          		show help
-	-j          		json output
+	-j          		json output, write the result document to stdout
+	-o <file>   		write the result document to the file
 	-skip-preflight		skip preflight check
-	-c <n>      		max concurrent preflight requests (default 8)`
+	-c <n>      		max concurrent preflight requests (default 8)
+	-bs <n>     		max resources per preflight request, larger groups are split (default 50)
+	-retries <n>		max retries of a throttled preflight request (default 3)
+	-timeout <duration>	max duration of a preflight request, including the polling of its long-running operation (default 5m)
+	-pc <n>     		max concurrent payload generation workers (default 4)
+	-provider-sources <list>	comma separated azurerm and azapi provider source addresses to check, e.g. "registry.terraform.io/hashicorp/azurerm",
+				by default the resources of any provider named azurerm or azapi are checked, including OpenTofu and registry mirrors
+
+Exit codes:
+	0			all resources passed
+	1			tool error, e.g. the plan file can't be read or the preflight requests failed to authenticate
+	2			validation findings, at least one resource failed the preflight or policy check
+	3			payload generation failures, the request payload of at least one resource couldn't be generated`
 
 func main() {
 	logrus.SetLevel(logrus.InfoLevel)
 
-	planfilepath := flag.String("i", "", "file path to terraform plan file")
+	planfilepath := flag.String("i", "", "file path to terraform plan file or plan JSON, \"-\" reads from stdin")
 	verbose := flag.Bool("v", false, "enable verbose logging")
 	help := flag.Bool("h", false, "show help")
 	jsonOutput := flag.Bool("j", false, "json output")
+	outputFilePath := flag.String("o", "", "file path to write the result document")
 	skipPreflight := flag.Bool("skip-preflight", false, "skip preflight check")
-	preflightConcurrency := flag.Int("c", 8, "max concurrent preflight requests")
+	preflightConcurrency := flag.Int("c", api.DefaultPreflightConcurrency, "max concurrent preflight requests")
+	preflightBatchSize := flag.Int("bs", api.DefaultMaxBatchSize, "max resources per preflight request")
+	preflightRetries := flag.Int("retries", api.DefaultMaxRetries, "max retries of a throttled preflight request")
+	preflightTimeout := flag.Duration("timeout", api.DefaultPreflightTimeout, "max duration of a preflight request")
+	payloadConcurrency := flag.Int("pc", plan.DefaultConcurrency, "max concurrent payload generation workers")
+	providerSources := flag.String("provider-sources", "", "comma separated provider source addresses to check")
 	flag.Parse()
 
 	if *help {
@@ -55,27 +76,48 @@ func main() {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 	}
 
-	execPath, err := tfclient.FindTerraform(context.TODO())
-	if err != nil {
-		logrus.Fatalf("failed to find terraform executable: %v", err)
+	result := report.New(VersionString())
+	writeResult := func() {
+		result.Finalize()
+		outputs := make([]string, 0)
+		if *jsonOutput {
+			outputs = append(outputs, "-")
+		}
+		if *outputFilePath != "" {
+			outputs = append(outputs, *outputFilePath)
+		}
+		for _, output := range outputs {
+			if err := result.Write(output); err != nil {
+				logrus.Errorf("failed to write result document: %v", err)
+				os.Exit(report.ExitCodeToolError)
+			}
+		}
 	}
-	logrus.Infof("terraform executable path: %s\n", execPath)
-
-	tf, err := tfexec.NewTerraform(path.Dir(*planfilepath), execPath)
-	if err != nil {
-		logrus.Fatalf("failed to create terraform client: %v", err)
+	fatalf := func(format string, args ...interface{}) {
+		result.SetError(errors.New(strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")))
+		writeResult()
+		logrus.Fatalf(format, args...)
 	}
 
 	logrus.Infof("reading terraform plan file: %s\n", *planfilepath)
-	tfplan, err := tf.ShowPlanFile(context.TODO(), *planfilepath)
+	tfplan, err := planfile.Load(context.TODO(), *planfilepath)
 	if err != nil {
-		logrus.Fatalf("failed to show plan file: %v\n", err)
+		fatalf("failed to read plan file: %v\n", err)
 	}
 
 	logrus.Infof("generating request body...\n")
-	models := plan.ExportAzurePayload(tfplan)
+	options := plan.Options{
+		Concurrency: *payloadConcurrency,
+	}
+	for _, source := range strings.Split(*providerSources, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			options.ProviderSources = append(options.ProviderSources, source)
+		}
+	}
+	models := plan.ExportAzurePayload(tfplan, options)
 	modelsToPreflight := make([]types.RequestModel, 0)
 	failedAddrs := make([]string, 0)
+	skippedUpdates := make(map[string]bool)
 	for _, model := range models {
 		if model.Failed != nil {
 			failedAddrs = append(failedAddrs, model.Address)
@@ -83,28 +125,73 @@ func main() {
 			logrus.Debugf("failed to generate request model for address: %s, error: %s\n", model.Address, model.Failed.Detail)
 			continue
 		}
-		logrus.Infof("%s: success\n", model.Address)
+		if model.Action == types.ActionReplace {
+			logrus.Infof("%s: success (replace)\n", model.Address)
+		} else {
+			logrus.Infof("%s: success\n", model.Address)
+		}
+		for _, warning := range model.Warnings {
+			logrus.Warnf("%s: %s\n", model.Address, warning)
+		}
 		logrus.Debugf("request model for address: %s, url: %s\nBody: %s\n", model.Address, model.URL, utils.FormatJson(model.Body))
 		logrus.Debugf("request model json: %s\n", utils.ToCompactJson(model))
+		if model.IsSkippedUpdate() {
+			skippedUpdates[model.Address] = true
+		}
+		if reason := model.PreflightSkipReason(); reason != "" {
+			logrus.Debugf("skipping preflight of the %s request %d for address: %s, %s\n", model.Method, model.Sequence, model.Address, reason)
+			continue
+		}
 		modelsToPreflight = append(modelsToPreflight, model)
 	}
 	logrus.Infof("total terraform resources: %d, success: %d, failed: %d\n", len(models), len(models)-len(failedAddrs), len(failedAddrs))
+	if len(skippedUpdates) > 0 {
+		logrus.Warnf("resources whose updates are not validated by the preflight API because their request bodies are partial: %d\n", len(skippedUpdates))
+	}
+	result.AddPayloads(models)
 
 	if *skipPreflight {
 		logrus.Infof("skipping preflight check...\n")
-		return
+		writeResult()
+		os.Exit(result.ExitCode())
 	}
 	if *preflightConcurrency <= 0 {
 		*preflightConcurrency = 1
 	}
 	logrus.Infof("sending preflight requests with concurrency: %d...\n", *preflightConcurrency)
-	preflightErrors := api.PreflightInBatch(context.TODO(), modelsToPreflight, *preflightConcurrency)
-	if len(preflightErrors) > 0 {
-		logrus.Infof("preflight errors: %d\n", len(preflightErrors))
-		for _, err := range preflightErrors {
-			logrus.Errorf("%s\n", err)
+	preflightResults := api.PreflightInBatch(context.TODO(), modelsToPreflight, api.PreflightOptions{
+		Concurrency:  *preflightConcurrency,
+		MaxBatchSize: *preflightBatchSize,
+		MaxRetries:   *preflightRetries,
+		Timeout:      *preflightTimeout,
+	})
+	failedResults := 0
+	for _, preflightResult := range preflightResults {
+		if preflightResult.RequestError != "" {
+			failedResults++
+			logrus.Errorf("address: %s, failed to send preflight request: %s\n", preflightResult.Address, preflightResult.RequestError)
+			continue
+		}
+		if preflightResult.PayloadError != "" {
+			failedResults++
+			logrus.Errorf("address: %s, failed to build preflight request: %s\n", preflightResult.Address, preflightResult.PayloadError)
+			continue
+		}
+		if len(preflightResult.Errors) == 0 {
+			continue
 		}
+		failedResults++
+		for _, preflightError := range preflightResult.Errors {
+			logrus.Errorf("address: %s, code: %s, error: %s\n", preflightResult.Address, preflightError.Code, preflightError.Message)
+		}
+	}
+	if failedResults > 0 {
+		logrus.Infof("preflight failed resources: %d\n", failedResults)
 	} else {
 		logrus.Infof("preflight check passed\n")
 	}
+	result.AddPreflightResults(preflightResults)
+
+	writeResult()
+	os.Exit(result.ExitCode())
 }